@@ -0,0 +1,325 @@
+package overseer
+
+//addresses in Config.Addresses are either plain TCP
+//host:port pairs, or URLs where the scheme selects the
+//network and the query string holds per-address options:
+//
+//  :3000
+//  tcp://:3000
+//  tcp://:3000?keepalive=30s
+//  tcp://:3000?proxy=on&proxy_trusted=10.0.0.0/8&proxy_timeout=5s
+//  unix:///run/app.sock?mode=0660&owner=www-data&group=www-data
+//  systemd://web
+//
+//proxy=on requires proxy_trusted, the load balancers which send
+//PROXY protocol headers, as the headers of any other source could
+//spoof the client's address. use 0.0.0.0/0,::/0 to trust all.
+//
+//Config.PacketAddresses are the same, except plain host:port
+//pairs are UDP and the schemes are udp:// and unixgram://.
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type address struct {
+	raw     string
+	network string
+	addr    string
+	//unix socket options
+	mode         os.FileMode
+	owner, group string
+	//tcp keep-alive period, negative when disabled
+	keepAlive time.Duration
+	//PROXY protocol options, nil when disabled
+	proxy *ProxyProtocol
+}
+
+func parseAddress(s string) (*address, error) {
+	return parseNetworkAddress(s, "tcp")
+}
+
+func parsePacketAddress(s string) (*address, error) {
+	return parseNetworkAddress(s, "udp")
+}
+
+//parseServiceAddress parses the address of a service of the
+//master process (Config.MetricsAddress and ControlAddress),
+//which is bound by the master process itself
+func parseServiceAddress(s string) (*address, error) {
+	a, err := parseAddress(s)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case a.network == "systemd":
+		return nil, fmt.Errorf("unsupported network %q", a.network)
+	case a.keepAlive != 0:
+		return nil, errors.New("unknown option \"keepalive\"")
+	case a.proxy != nil:
+		return nil, errors.New("unknown option \"proxy\"")
+	}
+	return a, nil
+}
+
+//parseControlAddress is parseServiceAddress for Config.ControlAddress,
+//which is a unix socket, as anyone who connects controls the service
+func parseControlAddress(s string) (*address, error) {
+	a, err := parseServiceAddress(s)
+	if err != nil {
+		return nil, err
+	} else if a.network != "unix" {
+		return nil, fmt.Errorf("unsupported network %q, requires a unix socket", a.network)
+	}
+	return a, nil
+}
+
+func parseNetworkAddress(s, defaultNetwork string) (*address, error) {
+	a := &address{raw: s, network: defaultNetwork, addr: s}
+	i := strings.Index(s, "://")
+	if i == -1 {
+		return a, nil
+	}
+	a.network = s[:i]
+	a.addr = s[i+3:]
+	query := ""
+	if q := strings.Index(a.addr, "?"); q != -1 {
+		query = a.addr[q+1:]
+		a.addr = a.addr[:q]
+	}
+	opts, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid options (%s)", err)
+	}
+	switch {
+	case defaultNetwork == "tcp" && (a.network == "tcp" || a.network == "tcp4" || a.network == "tcp6"):
+	case defaultNetwork == "udp" && (a.network == "udp" || a.network == "udp4" || a.network == "udp6"):
+	case defaultNetwork == "tcp" && a.network == "unix",
+		defaultNetwork == "udp" && a.network == "unixgram":
+		if a.addr == "" {
+			return nil, errors.New("missing socket path")
+		}
+	case a.network == "systemd":
+		if a.addr == "" {
+			return nil, errors.New("missing socket name")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported network %q", a.network)
+	}
+	keys := make([]string, 0, len(opts))
+	for k := range opts {
+		keys = append(keys, k)
+	}
+	//proxy is enabled before its options
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := opts.Get(k)
+		switch {
+		case k == "mode" && a.isUnix():
+			m, err := strconv.ParseUint(v, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode %q", v)
+			}
+			a.mode = os.FileMode(m)
+		case k == "owner" && a.isUnix():
+			a.owner = v
+		case k == "group" && a.isUnix():
+			a.group = v
+		case k == "keepalive" && defaultNetwork == "tcp" && a.network != "unix":
+			if v == "off" {
+				a.keepAlive = -1
+				continue
+			}
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("invalid keepalive %q", v)
+			}
+			a.keepAlive = d
+		case strings.HasPrefix(k, "proxy") && defaultNetwork == "tcp" && a.network != "unix":
+			if err := a.parseProxyOption(k, v); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	if a.proxy != nil && len(a.proxy.Trusted) == 0 {
+		return nil, errors.New("proxy=on requires proxy_trusted")
+	}
+	return a, nil
+}
+
+func (a *address) parseProxyOption(k, v string) error {
+	if k == "proxy" {
+		switch v {
+		case "on":
+			a.proxy = &ProxyProtocol{}
+		case "off":
+		default:
+			return fmt.Errorf("invalid proxy %q", v)
+		}
+		return nil
+	}
+	if a.proxy == nil {
+		return fmt.Errorf("option %q requires proxy=on", k)
+	}
+	switch k {
+	case "proxy_trusted":
+		nets, err := parseTrusted(v)
+		if err != nil {
+			return fmt.Errorf("invalid proxy_trusted %q", v)
+		}
+		a.proxy.Trusted = nets
+	case "proxy_timeout":
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid proxy_timeout %q", v)
+		}
+		a.proxy.Timeout = d
+	default:
+		return fmt.Errorf("unknown option %q", k)
+	}
+	return nil
+}
+
+func (a *address) isUnix() bool {
+	return a.network == "unix" || a.network == "unixgram"
+}
+
+//abstract unix sockets have no file on disk
+func (a *address) abstract() bool {
+	return strings.HasPrefix(a.addr, "@")
+}
+
+func (a *address) listen() (net.Listener, error) {
+	if a.network == "unix" {
+		if err := a.prepareUnix(); err != nil {
+			return nil, err
+		}
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: a.addr, Net: "unix"})
+		if err != nil {
+			return nil, err
+		}
+		//the socket file must outlive this listener, since
+		//it is closed as soon as its descriptor is retrieved
+		l.SetUnlinkOnClose(false)
+		if err := a.chownUnix(); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	}
+	t, err := net.ResolveTCPAddr(a.network, a.addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address %s (%s)", a.raw, err)
+	}
+	return net.ListenTCP(a.network, t)
+}
+
+func (a *address) listenPacket() (net.PacketConn, error) {
+	if a.network == "unixgram" {
+		if err := a.prepareUnix(); err != nil {
+			return nil, err
+		}
+		c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: a.addr, Net: "unixgram"})
+		if err != nil {
+			return nil, err
+		}
+		if err := a.chownUnix(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	u, err := net.ResolveUDPAddr(a.network, a.addr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid address %s (%s)", a.raw, err)
+	}
+	return net.ListenUDP(a.network, u)
+}
+
+func (a *address) prepareUnix() error {
+	if a.abstract() {
+		return nil
+	}
+	return removeStaleSocket(a.network, a.addr)
+}
+
+func (a *address) chownUnix() error {
+	if a.abstract() {
+		return nil
+	}
+	if a.mode != 0 {
+		if err := os.Chmod(a.addr, a.mode); err != nil {
+			return fmt.Errorf("Failed to chmod %s (%s)", a.addr, err)
+		}
+	}
+	if a.owner != "" || a.group != "" {
+		uid, gid, err := lookupOwner(a.owner, a.group)
+		if err == nil {
+			err = os.Chown(a.addr, uid, gid)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to chown %s (%s)", a.addr, err)
+		}
+	}
+	return nil
+}
+
+//removeStaleSocket removes a socket file left behind by a
+//previous run, refusing to touch regular files or sockets
+//which are still bound.
+func removeStaleSocket(network, path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if c, err := net.DialTimeout(network, path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("%s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+//lookupOwner resolves user and group names (or numeric ids),
+//an empty name is returned as -1 which os.Chown leaves unchanged.
+func lookupOwner(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			if u, err = user.LookupId(owner); err != nil {
+				return 0, 0, err
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, err
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			if g, err = user.LookupGroupId(group); err != nil {
+				return 0, 0, err
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, err
+		}
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,126 @@
+package overseer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNetworkAddress(t *testing.T) {
+	for _, test := range []struct {
+		addr, defaultNetwork string
+		want                 address
+		err                  string
+	}{
+		{addr: ":3000", defaultNetwork: "tcp", want: address{network: "tcp", addr: ":3000"}},
+		{addr: ":3000", defaultNetwork: "udp", want: address{network: "udp", addr: ":3000"}},
+		{addr: "tcp4://127.0.0.1:3000", defaultNetwork: "tcp", want: address{network: "tcp4", addr: "127.0.0.1:3000"}},
+		{addr: "udp6://[::1]:53", defaultNetwork: "udp", want: address{network: "udp6", addr: "[::1]:53"}},
+		{addr: "tcp://:3000?keepalive=30s", defaultNetwork: "tcp", want: address{network: "tcp", addr: ":3000", keepAlive: 30 * time.Second}},
+		{addr: "tcp://:3000?keepalive=off", defaultNetwork: "tcp", want: address{network: "tcp", addr: ":3000", keepAlive: -1}},
+		{addr: "tcp://:3000?keepalive=0s", defaultNetwork: "tcp", err: `invalid keepalive "0s"`},
+		{addr: "unix:///run/app.sock?mode=0660&owner=www&group=web", defaultNetwork: "tcp",
+			want: address{network: "unix", addr: "/run/app.sock", mode: 0660, owner: "www", group: "web"}},
+		{addr: "unixgram:///run/app.sock?mode=0600", defaultNetwork: "udp", want: address{network: "unixgram", addr: "/run/app.sock", mode: 0600}},
+		{addr: "unix://@app", defaultNetwork: "tcp", want: address{network: "unix", addr: "@app"}},
+		{addr: "unix:///run/app.sock?mode=rw", defaultNetwork: "tcp", err: `invalid mode "rw"`},
+		{addr: "unix://", defaultNetwork: "tcp", err: "missing socket path"},
+		{addr: "unix:///run/app.sock?keepalive=30s", defaultNetwork: "tcp", err: `unknown option "keepalive"`},
+		{addr: "unix:///run/app.sock?proxy=on", defaultNetwork: "tcp", err: `unknown option "proxy"`},
+		{addr: "tcp://:3000?mode=0600", defaultNetwork: "tcp", err: `unknown option "mode"`},
+		{addr: "udp://:53?keepalive=30s", defaultNetwork: "udp", err: `unknown option "keepalive"`},
+		{addr: "systemd://web", defaultNetwork: "tcp", want: address{network: "systemd", addr: "web"}},
+		{addr: "systemd://", defaultNetwork: "tcp", err: "missing socket name"},
+		{addr: "udp://:53", defaultNetwork: "tcp", err: `unsupported network "udp"`},
+		{addr: "unix:///run/app.sock", defaultNetwork: "udp", err: `unsupported network "unix"`},
+		{addr: "tcp://:3000?a=%zz", defaultNetwork: "tcp", err: `invalid options (invalid URL escape "%zz")`},
+		{addr: "tcp://:3000?proxy=on", defaultNetwork: "tcp", err: "proxy=on requires proxy_trusted"},
+		{addr: "tcp://:3000?proxy=yes", defaultNetwork: "tcp", err: `invalid proxy "yes"`},
+		{addr: "tcp://:3000?proxy_trusted=10.0.0.0/8", defaultNetwork: "tcp", err: `option "proxy_trusted" requires proxy=on`},
+		{addr: "tcp://:3000?proxy=on&proxy_trusted=10.0.0.0/33", defaultNetwork: "tcp", err: `invalid proxy_trusted "10.0.0.0/33"`},
+		{addr: "tcp://:3000?proxy=on&proxy_trusted=10.0.0.1&proxy_timeout=-1s", defaultNetwork: "tcp", err: `invalid proxy_timeout "-1s"`},
+		{addr: "tcp://:3000?proxy=on&proxy_trusted=10.0.0.1&proxy_other=1", defaultNetwork: "tcp", err: `unknown option "proxy_other"`},
+		{addr: "tcp://:3000?proxy=off&keepalive=1m", defaultNetwork: "tcp", want: address{network: "tcp", addr: ":3000", keepAlive: time.Minute}},
+	} {
+		a, err := parseNetworkAddress(test.addr, test.defaultNetwork)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: expected error %q, got %v", test.addr, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.addr, err)
+			continue
+		}
+		test.want.raw = test.addr
+		if *a != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.addr, test.want, *a)
+		}
+	}
+}
+
+func TestParseProxyOptions(t *testing.T) {
+	a, err := parseAddress("tcp://:3000?proxy=on&proxy_trusted=10.0.0.0/8,192.0.2.1,2001:db8::1&proxy_timeout=2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.proxy == nil || a.proxy.Timeout != 2*time.Second {
+		t.Fatalf("unexpected proxy options %+v", a.proxy)
+	}
+	nets := []string{}
+	for _, n := range a.proxy.Trusted {
+		nets = append(nets, n.String())
+	}
+	if got, want := strings.Join(nets, ","), "10.0.0.0/8,192.0.2.1/32,2001:db8::1/128"; got != want {
+		t.Fatalf("expected trusted %s, got %s", want, got)
+	}
+}
+
+func TestParseControlAddress(t *testing.T) {
+	for _, test := range []struct {
+		addr string
+		err  bool
+	}{
+		{"unix:///run/app.ctl?mode=0600", false},
+		{"unix://@app.ctl", false},
+		{"localhost:9100", true},
+		{"tcp://127.0.0.1:9100", true},
+		{"systemd://control", true},
+	} {
+		_, err := parseControlAddress(test.addr)
+		if test.err && err == nil {
+			t.Errorf("%s: expected an error", test.addr)
+		} else if !test.err && err != nil {
+			t.Errorf("%s: %s", test.addr, err)
+		}
+	}
+}
+
+func TestParseServiceAddress(t *testing.T) {
+	for _, test := range []struct {
+		addr, network string
+		err           bool
+	}{
+		{"localhost:9100", "tcp", false},
+		{"tcp6://[::1]:9100", "tcp6", false},
+		{"unix:///run/app.ctl?mode=0600", "unix", false},
+		{"systemd://metrics", "", true},
+		{"udp://:9100", "", true},
+		{"tcp://:9100?keepalive=30s", "", true},
+		{"tcp://:9100?proxy=on&proxy_trusted=10.0.0.0/8", "", true},
+	} {
+		a, err := parseServiceAddress(test.addr)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", test.addr, err)
+		} else if a.network != test.network {
+			t.Errorf("%s: expected network %s, got %s", test.addr, test.network, a.network)
+		}
+	}
+}
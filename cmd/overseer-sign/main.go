@@ -0,0 +1,109 @@
+//overseer-sign creates keys and signatures for
+//overseer.Config.PublicKeys
+//
+//  overseer-sign -generate app.key         writes a private key, prints its public key
+//  overseer-sign -key app.key my_app.gz    writes my_app.gz.sig
+//  overseer-sign -verify PUBKEY my_app.gz  checks my_app.gz.sig
+//
+//sign the files as they are published, gzipped binaries
+//are verified before they are extracted
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jpillora/overseer"
+)
+
+const usage = `Usage: overseer-sign [options] file...
+
+  overseer-sign -generate app.key         writes a private key, prints its public key
+  overseer-sign -key app.key my_app.gz    writes my_app.gz.sig
+  overseer-sign -verify PUBKEY my_app.gz  checks my_app.gz.sig
+
+Sign the files as they are published (such as my_app.gz,
+not the extracted my_app), since they're verified before
+they are extracted.
+
+Options:
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	generate := flag.String("generate", "", "write a new private key to this file")
+	keyPath := flag.String("key", "", "private key file used to sign")
+	verify := flag.String("verify", "", "public key used to verify")
+	flag.Parse()
+	switch {
+	case *generate != "":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		seed := base64.StdEncoding.EncodeToString(priv.Seed())
+		if err := ioutil.WriteFile(*generate, []byte(seed+"\n"), 0600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(pub))
+	case *keyPath != "":
+		b, err := ioutil.ReadFile(*keyPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			log.Fatalf("invalid private key %s", *keyPath)
+		}
+		key := ed25519.NewKeyFromSeed(seed)
+		for _, path := range flag.Args() {
+			f, err := os.Open(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			sig, err := overseer.Sign(key, f)
+			f.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path+".sig", sig, 0644); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("signed %s\n", path)
+		}
+	case *verify != "":
+		pub, err := overseer.ParsePublicKey(*verify)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, path := range flag.Args() {
+			sig, err := ioutil.ReadFile(path + ".sig")
+			if err != nil {
+				log.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = overseer.Verify([]ed25519.PublicKey{pub}, f, sig)
+			f.Close()
+			if err != nil {
+				log.Fatalf("%s: %s", path, err)
+			}
+			fmt.Printf("verified %s\n", path)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+}
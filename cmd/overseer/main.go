@@ -0,0 +1,124 @@
+//overseer runs any executable with zero-downtime restarts and
+//upgrades (see overseer.Config.Command). The sockets are bound
+//once and passed to the executable with systemd socket activation
+//(LISTEN_FDS), so it must support sd_listen_fds(3).
+//
+//  overseer -addr :3000 -url https://example.com/app -pubkey KEY -- ./app --flag
+//
+//Restarts start the new executable first. Without -ready-url,
+//it's assumed to be ready as soon as it's started, otherwise
+//the URL (such as http://localhost:3000/ready?pid={pid}) must
+//only respond with 2xx from the executable with that pid.
+//
+//The executable is restarted with SIGUSR2 (or overseerctl restart),
+//and replaced when the fetcher finds a new binary, which must be
+//signed (see overseer-sign) or listed in a -checksum-url manifest.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jpillora/overseer"
+	"github.com/jpillora/overseer/fetcher"
+)
+
+//list is a repeatable flag
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+//signals by name, see signals_posix.go
+var signals = map[string]os.Signal{
+	"TERM": overseer.SIGTERM,
+	"INT":  os.Interrupt,
+	"USR1": overseer.SIGUSR1,
+	"USR2": overseer.SIGUSR2,
+}
+
+func main() {
+	c := overseer.Config{Required: true}
+	var addrs, packetAddrs, keys list
+	flag.Var(&addrs, "addr", "listening address, repeatable (see Config.Addresses)")
+	flag.Var(&packetAddrs, "packet-addr", "packet address, repeatable (see Config.PacketAddresses)")
+	url := flag.String("url", "", "fetch new binaries from this URL")
+	checksumURL := flag.String("checksum-url", "", "verify binaries fetched from -url with this sha256sum manifest")
+	file := flag.String("file", "", "fetch new binaries from this file")
+	interval := flag.Duration("interval", 0, "fetch interval (defaults to the fetcher's default)")
+	flag.Var(&keys, "pubkey", "public key to verify fetched binaries, repeatable (see overseer-sign).\nfetched binaries require -pubkey or -checksum-url")
+	drain := flag.String("drain-signal", "TERM", "signal which gracefully stops the executable (TERM, INT, HUP, QUIT, USR1 or USR2)")
+	flag.DurationVar(&c.TerminateTimeout, "timeout", 30*time.Second, "how long to wait for the executable to stop")
+	flag.IntVar(&c.Workers, "workers", 1, "number of executables to run in parallel")
+	readyURL := flag.String("ready-url", "", "restarts wait until this URL responds with 2xx, it must contain {pid},\nwhich is replaced by the new executable's pid. only the executable with\nthat pid may respond with 2xx, as all of them serve the same sockets")
+	flag.DurationVar(&c.ReadyTimeout, "ready-timeout", 30*time.Second, "how long restarts wait for -ready-url")
+	flag.BoolVar(&c.Supervise, "supervise", false, "restart the executable when it crashes")
+	flag.StringVar(&c.ControlAddress, "control", "", "control unix socket address (see overseerctl)")
+	flag.StringVar(&c.MetricsAddress, "metrics", "", "metrics address")
+	flag.BoolVar(&c.Debug, "debug", false, "enable debug logs")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: overseer [options] -- command [args...]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	c.Command = flag.Args()
+	c.Addresses = addrs
+	c.PacketAddresses = packetAddrs
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(*drain), "SIG")]
+	if !ok {
+		log.Fatalf("unknown signal %s", *drain)
+	}
+	c.DrainSignal = sig
+	for _, k := range keys {
+		pub, err := overseer.ParsePublicKey(k)
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.PublicKeys = append(c.PublicKeys, pub)
+	}
+	switch {
+	case *url != "" && *file != "":
+		log.Fatal("-url and -file cant both be set")
+	case (*url != "" && *checksumURL == "" || *file != "") && len(keys) == 0:
+		log.Fatal("fetched binaries must be verified, set -pubkey (or -checksum-url with -url)")
+	case *url != "":
+		c.Fetcher = &fetcher.HTTP{URL: *url, Interval: *interval, ChecksumURL: *checksumURL}
+	case *file != "":
+		c.Fetcher = &fetcher.File{Path: *file, Interval: *interval}
+	}
+	if *readyURL != "" {
+		if !strings.Contains(*readyURL, "{pid}") {
+			log.Fatal("-ready-url must contain {pid}, otherwise the old executable responds")
+		}
+		client := &http.Client{Timeout: 5 * time.Second}
+		c.ReadyProbe = func(pid int) error {
+			u := strings.Replace(*readyURL, "{pid}", strconv.Itoa(pid), -1)
+			resp, err := client.Get(u)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode/100 != 2 {
+				return fmt.Errorf("%s responded with %s", u, resp.Status)
+			}
+			return nil
+		}
+	}
+	overseer.Run(c)
+}
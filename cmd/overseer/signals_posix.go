@@ -0,0 +1,11 @@
+// +build linux darwin freebsd
+
+package main
+
+import "syscall"
+
+//not all operating systems define these
+func init() {
+	signals["HUP"] = syscall.SIGHUP
+	signals["QUIT"] = syscall.SIGQUIT
+}
@@ -0,0 +1,163 @@
+//overseerctl drives a running overseer master process
+//over its control socket (see overseer.Config.ControlAddress)
+//
+//  overseerctl -socket /run/app.ctl status     show the master and its programs
+//  overseerctl -socket /run/app.ctl restart    gracefully restart the programs
+//  overseerctl -socket /run/app.ctl fetch      fetch an upgrade now
+//  overseerctl -socket /run/app.ctl rollback   restore the previous binary
+//  overseerctl -socket /run/app.ctl events     tail the event stream
+//
+//pause, resume and shutdown are also passed through. the socket
+//defaults to $OVERSEER_CONTROL, and -json prints the raw replies.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jpillora/overseer"
+)
+
+func main() {
+	socket := flag.String("socket", os.Getenv("OVERSEER_CONTROL"), "control socket path or unix:// address")
+	jsonOut := flag.Bool("json", false, "print replies as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: overseerctl [-socket path] [-json] status|restart|fetch|rollback|events|pause|resume|shutdown\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *socket == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	log.SetFlags(0)
+	command := flag.Arg(0)
+	conn, err := dial(*socket)
+	if err != nil {
+		log.Fatalf("failed to connect to %s (%s)", *socket, err)
+	}
+	defer conn.Close()
+	if err := json.NewEncoder(conn).Encode(overseer.ControlRequest{Command: command}); err != nil {
+		log.Fatal(err)
+	}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		resp := overseer.ControlResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Fatalf("invalid reply (%s)", err)
+		}
+		if !resp.OK {
+			log.Fatalf("%s failed: %s", command, resp.Error)
+		}
+		if *jsonOut {
+			if resp.Event != nil || command != "events" {
+				fmt.Println(scanner.Text())
+			}
+		} else if resp.Status != nil {
+			printStatus(resp.Status)
+		} else if resp.Event != nil {
+			printEvent(resp.Event)
+		} else if command != "events" {
+			fmt.Println("ok")
+		}
+		if command != "events" {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if command != "events" {
+		log.Fatal(errors.New("no reply"))
+	}
+}
+
+//dial accepts the same form as ControlAddress, and plain paths
+func dial(socket string) (net.Conn, error) {
+	addr := socket
+	if strings.HasPrefix(addr, "unix://") {
+		addr = strings.TrimPrefix(addr, "unix://")
+		if i := strings.Index(addr, "?"); i != -1 {
+			addr = addr[:i]
+		}
+	}
+	return net.DialTimeout("unix", addr, 5*time.Second)
+}
+
+func printStatus(s *overseer.Status) {
+	fmt.Printf("master   pid %d, up %s, hash %s\n", s.PID, seconds(s.Uptime), short(s.Hash))
+	if s.PreviousHash != "" {
+		fmt.Printf("previous %s\n", short(s.PreviousHash))
+	}
+	switch {
+	case s.ShuttingDown:
+		fmt.Println("state    shutting down")
+	case s.Restarting:
+		fmt.Println("state    restarting")
+	default:
+		fmt.Println("state    running")
+	}
+	fetching := "disabled"
+	if s.Fetching && s.FetchPaused {
+		fetching = "paused"
+	} else if s.Fetching {
+		fetching = "enabled"
+	}
+	if f := s.LastFetch; f != nil {
+		fetching += fmt.Sprintf(", last %s %s ago", f.Result, seconds(time.Since(f.Time).Seconds()))
+		if f.Error != "" {
+			fetching += " (" + f.Error + ")"
+		}
+	}
+	fmt.Printf("fetch    %s\n", fetching)
+	for _, sl := range s.Slaves {
+		ready := "ready"
+		if !sl.Ready {
+			ready = "not ready"
+		}
+		fmt.Printf("slave#%d  worker %d, pid %d, up %s, hash %s, %s\n",
+			sl.ID, sl.Worker, sl.PID, seconds(sl.Uptime), short(sl.Hash), ready)
+	}
+}
+
+func printEvent(e *overseer.Event) {
+	line := e.Time.Format("2006-01-02 15:04:05.000") + " " + string(e.Type)
+	if e.SlaveID != 0 {
+		line += fmt.Sprintf(" slave#%d worker=%d pid=%d", e.SlaveID, e.Worker, e.PID)
+	}
+	if e.Type == overseer.EventSlaveExited || e.Type == overseer.EventRollback {
+		line += fmt.Sprintf(" exit_code=%d", e.ExitCode)
+	}
+	if e.Hash != "" {
+		line += " hash=" + short(e.Hash)
+	}
+	if e.PreviousHash != "" {
+		line += " previous_hash=" + short(e.PreviousHash)
+	}
+	if e.Duration > 0 {
+		line += " duration=" + e.Duration.Round(time.Millisecond).String()
+	}
+	if e.Err != nil {
+		line += " error=" + e.Err.Error()
+	}
+	fmt.Println(line)
+}
+
+func short(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
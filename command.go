@@ -0,0 +1,80 @@
+package overseer
+
+//with Config.Command, the slave processes run an external
+//program instead of this binary. the sockets are passed to it
+//with systemd socket activation (see sd_listen_fds(3)), which
+//requires LISTEN_PID to be its own pid. since the pid isn't
+//known until it starts, it is started by a shell which sets
+//LISTEN_PID to its own pid, then execs the program in place.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const commandScript = `LISTEN_PID=$$; export LISTEN_PID; exec "$@"`
+
+//commandPath is the absolute path of the program, which
+//is replaced by fetched binaries
+func commandPath(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(path)
+}
+
+//drainSignal asks slaves to shut down gracefully
+func (mp *master) drainSignal() os.Signal {
+	if len(mp.Command) > 0 {
+		return mp.DrainSignal
+	}
+	return mp.RestartSignal
+}
+
+//startCommand is startSlave for Config.Command
+func (mp *master) startCommand(s *slaveProcess, w *worker) (*slaveProcess, error) {
+	cmd := s.cmd
+	cmd.Path = "/bin/sh"
+	cmd.Args = append([]string{"sh", "-c", commandScript, "sh", mp.binPath}, mp.Command[1:]...)
+	e := os.Environ()
+	if n := len(mp.slaveExtraFiles); n > 0 {
+		names := make([]string, n)
+		for i := range names {
+			names[i] = "unknown" //systemd default
+			if i < len(mp.slaveFDNames) && mp.slaveFDNames[i] != "" {
+				names[i] = mp.slaveFDNames[i]
+			}
+		}
+		e = append(e, envListenFDs+"="+strconv.Itoa(n))
+		e = append(e, envListenFDNames+"="+strings.Join(names, ":"))
+	}
+	e = append(e, envBinID+"="+hex.EncodeToString(s.binHash))
+	e = append(e, envSlaveID+"="+strconv.Itoa(s.id))
+	e = append(e, envWorkerIndex+"="+strconv.Itoa(w.index))
+	e = append(e, envWorkerCount+"="+strconv.Itoa(len(mp.workers)))
+	cmd.Env = e
+	cmd.ExtraFiles = mp.slaveExtraFiles
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Failed to start command: %s", err)
+	}
+	mp.trackSlave(s)
+	mp.emit(slaveEvent(EventSlaveStarted, s))
+	go mp.wait(s)
+	//commands can't report back, so they're
+	//either probed or assumed to be ready
+	if mp.ReadyProbe != nil {
+		go mp.probe(s)
+	} else {
+		mp.handleMessage(s, msgReady, "")
+	}
+	return s, nil
+}
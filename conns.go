@@ -0,0 +1,159 @@
+package overseer
+
+//with Config.HandoffConns, each new program listens on its
+//worker's unix socket, in a directory private to the master
+//process. the old program connects to it once for each handed
+//off connection, and passes the connection's descriptor with
+//SCM_RIGHTS, followed by its metadata.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const envConnsSocket = "OVERSEER_CONNS_SOCKET"
+
+//ResumedConn is a connection handed off by the previous program
+type ResumedConn struct {
+	Conn net.Conn
+	//Metadata passed to State.HandoffConn
+	Metadata []byte
+}
+
+//HandoffConn passes an accepted connection, along with metadata
+//describing it (such as the session or protocol state), to the next
+//program of this worker, which receives it from State.ResumedConns.
+//Call it after GracefulShutdown is closed, once nothing is buffered
+//on the connection. The connection is closed in this program once
+//it has been passed. Requires Config.HandoffConns.
+func (s State) HandoffConn(conn net.Conn, metadata []byte) error {
+	if s.handoffConn == nil {
+		return errors.New("overseer: connection handoff not enabled")
+	}
+	return s.handoffConn(conn, metadata)
+}
+
+//connsSocket of a worker's programs
+func (mp *master) connsSocket(w *worker) string {
+	return filepath.Join(mp.connsDir, "worker-"+strconv.Itoa(w.index)+".sock")
+}
+
+//initConnsDir is called once the master process is running
+func (mp *master) initConnsDir() error {
+	if !mp.HandoffConns || mp.connsDir != "" {
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "overseer-conns-")
+	if err != nil {
+		return fmt.Errorf("Failed to create connection handoff directory (%s)", err)
+	}
+	mp.connsDir = dir
+	return nil
+}
+
+func (mp *master) removeConnsDir() {
+	if mp.connsDir != "" {
+		os.RemoveAll(mp.connsDir)
+	}
+}
+
+//listenConns replaces the previous program's listener
+//on the worker's socket, and receives its connections
+func (sp *slave) listenConns() error {
+	path := os.Getenv(envConnsSocket)
+	if path == "" {
+		return nil
+	}
+	os.Remove(path)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("failed to listen for connection handoffs (%s)", err)
+	}
+	//the socket file belongs to the next program once replaced
+	l.SetUnlinkOnClose(false)
+	sp.connsListener = l
+	resumed := make(chan ResumedConn)
+	sp.state.ResumedConns = resumed
+	sp.state.handoffConn = sp.handoffConn
+	go func() {
+		for {
+			u, err := l.AcceptUnix()
+			if err != nil {
+				return
+			}
+			go sp.resumeConn(u, resumed)
+		}
+	}()
+	return nil
+}
+
+func (sp *slave) resumeConn(u *net.UnixConn, resumed chan ResumedConn) {
+	defer u.Close()
+	u.SetReadDeadline(time.Now().Add(sp.TerminateTimeout))
+	conn, metadata, err := recvConn(u, sp.HandoffLimit)
+	if err != nil {
+		sp.warnf("failed to resume connection (%s)", err)
+		return
+	}
+	resumed <- ResumedConn{Conn: conn, Metadata: metadata}
+}
+
+//handoffConn is State.HandoffConn
+func (sp *slave) handoffConn(conn net.Conn, metadata []byte) error {
+	if int64(len(metadata)) > sp.HandoffLimit {
+		return ErrHandoffTooLarge
+	}
+	//the connection's own descriptor
+	c := conn
+	if oc, ok := c.(overseerConn); ok {
+		c = oc.Conn
+	}
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errors.New("overseer: connection has no file descriptor")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	//the next program may not be listening yet
+	path := os.Getenv(envConnsSocket)
+	deadline := time.Now().Add(sp.TerminateTimeout)
+	var u *net.UnixConn
+	for {
+		u, err = net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+		if err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			return fmt.Errorf("overseer: connection handoff failed (%s)", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer u.Close()
+	u.SetWriteDeadline(deadline)
+	var sendErr error
+	if err := raw.Control(func(fd uintptr) {
+		sendErr = sendConn(u, fd, metadata)
+	}); err != nil {
+		return err
+	}
+	if sendErr != nil {
+		return fmt.Errorf("overseer: connection handoff failed (%s)", sendErr)
+	}
+	return conn.Close()
+}
+
+//closeConns stops receiving connections, the
+//next program's listener replaces this one
+func (sp *slave) closeConns() {
+	if sp.connsListener != nil {
+		sp.connsListener.Close()
+	}
+}
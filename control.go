@@ -0,0 +1,317 @@
+package overseer
+
+//the master process can be driven over Config.ControlAddress.
+//clients write one request per line, either a JSON encoded
+//ControlRequest or just the command, and receive one JSON
+//encoded ControlResponse per line:
+//
+//  $ echo status | nc -U /run/app.ctl
+//  {"ok":true,"status":{"pid":1234,"hash":"9f86d0...",...}}
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+//ControlRequest is sent to the control socket. Commands are:
+//
+//  status    reply with the Status of the master process
+//  restart   gracefully restart the programs
+//  fetch     fetch now, instead of waiting for the fetcher's interval
+//  pause     stop fetching, until resumed
+//  resume    resume fetching
+//  rollback  restore the previous binary and restart, the replaced
+//            binary isn't installed again (see Config.RollbackWindow)
+//  events    reply, then stream each Event as a ControlResponse
+//  shutdown  gracefully shut down, see Shutdown
+type ControlRequest struct {
+	Command string `json:"command"`
+}
+
+//ControlResponse is the reply to a ControlRequest
+type ControlResponse struct {
+	OK     bool    `json:"ok"`
+	Error  string  `json:"error,omitempty"`
+	Status *Status `json:"status,omitempty"`
+	Event  *Event  `json:"event,omitempty"`
+}
+
+//Status describes the master process and its programs
+type Status struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+	//Uptime of the master process, in seconds
+	Uptime float64 `json:"uptime"`
+	//Hash of the current binary, see State.ID
+	Hash string `json:"hash"`
+	//PreviousHash is the binary kept for rollbacks,
+	//see Config.RollbackWindow
+	PreviousHash string `json:"previous_hash,omitempty"`
+	Restarting   bool   `json:"restarting"`
+	ShuttingDown bool   `json:"shutting_down"`
+	//Fetching is false without a Config.Fetcher
+	Fetching    bool          `json:"fetching"`
+	FetchPaused bool          `json:"fetch_paused"`
+	LastFetch   *FetchStatus  `json:"last_fetch,omitempty"`
+	Slaves      []SlaveStatus `json:"slaves"`
+}
+
+//FetchStatus is the result of the last completed fetch
+type FetchStatus struct {
+	Time time.Time `json:"time"`
+	//Result is the type of the fetch's final Event,
+	//such as "fetch-no-update" or "binary-replaced"
+	Result EventType `json:"result"`
+	Hash   string    `json:"hash,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+//SlaveStatus describes the program run by each worker
+type SlaveStatus struct {
+	ID        int       `json:"id"`
+	Worker    int       `json:"worker"`
+	PID       int       `json:"pid"`
+	Hash      string    `json:"hash"`
+	StartedAt time.Time `json:"started_at"`
+	//Uptime of the program, in seconds
+	Uptime float64 `json:"uptime"`
+	Ready  bool    `json:"ready"`
+}
+
+//serveControl is run once the sockets are retrieved,
+//and runs until the master process has stopped
+func (mp *master) serveControl() error {
+	a, err := parseControlAddress(mp.ControlAddress)
+	if err != nil {
+		return fmt.Errorf("Invalid control address %s (%s)", mp.ControlAddress, err)
+	}
+	l, err := a.listen()
+	if err != nil {
+		return err
+	}
+	if u, ok := l.(*net.UnixListener); ok {
+		u.SetUnlinkOnClose(true)
+	}
+	go func() {
+		<-mp.stopped
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go mp.handleControl(conn)
+		}
+	}()
+	mp.debugf("control socket on %s", mp.ControlAddress)
+	return nil
+}
+
+func (mp *master) handleControl(conn net.Conn) {
+	defer conn.Close()
+	enc := json.NewEncoder(conn)
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		req := ControlRequest{Command: line}
+		if strings.HasPrefix(line, "{") {
+			req = ControlRequest{}
+			if err := json.Unmarshal([]byte(line), &req); err != nil {
+				enc.Encode(ControlResponse{Error: fmt.Sprintf("invalid request (%s)", err)})
+				continue
+			}
+		}
+		if req.Command == "events" {
+			mp.streamEvents(conn, enc)
+			return
+		}
+		if err := enc.Encode(mp.control(req)); err != nil {
+			return
+		}
+	}
+}
+
+func (mp *master) control(req ControlRequest) ControlResponse {
+	mp.logger().with("command", req.Command).debugf("control command: %s", req.Command)
+	var err error
+	switch req.Command {
+	case "status":
+		return ControlResponse{OK: true, Status: mp.status()}
+	case "restart":
+		if mp.isRestarting() {
+			err = errors.New("already restarting")
+		} else if !mp.running() {
+			err = errors.New("no program running")
+		} else {
+			go mp.triggerRestart()
+		}
+	case "fetch":
+		err = mp.fetchNowCommand()
+	case "rollback":
+		err = mp.rollback()
+	case "pause", "resume":
+		mp.controlMux.Lock()
+		mp.fetchPaused = req.Command == "pause"
+		mp.controlMux.Unlock()
+	case "shutdown":
+		go mp.shutdownTimeout(mp.TerminateTimeout)
+	default:
+		err = fmt.Errorf("unknown command %q", req.Command)
+	}
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	return ControlResponse{OK: true}
+}
+
+func (mp *master) fetchNowCommand() error {
+	if mp.fetcher == nil {
+		return errors.New("no fetcher")
+	} else if mp.isFetchPaused() {
+		return errors.New("fetching is paused")
+	} else if mp.fetchCtx.Err() != nil {
+		return errors.New("fetching stopped")
+	}
+	//wakes the fetch loop, or the fetcher waiting for its interval
+	select {
+	case mp.fetchNow <- true:
+		return nil
+	case <-time.After(time.Second):
+		return errors.New("fetch already in progress")
+	}
+}
+
+//streamEvents writes events until the client disconnects
+func (mp *master) streamEvents(conn net.Conn, enc *json.Encoder) {
+	mp.debugf("control client streaming events")
+	events := mp.subscribe()
+	defer mp.unsubscribe(events)
+	closed := make(chan bool)
+	go func() {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}()
+	if err := enc.Encode(ControlResponse{OK: true}); err != nil {
+		return
+	}
+	for {
+		select {
+		case e := <-events:
+			if err := enc.Encode(ControlResponse{OK: true, Event: &e}); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-mp.stopped:
+			return
+		}
+	}
+}
+
+func (mp *master) subscribe() chan Event {
+	events := make(chan Event, 64)
+	mp.controlMux.Lock()
+	if mp.subscribers == nil {
+		mp.subscribers = map[chan Event]bool{}
+	}
+	mp.subscribers[events] = true
+	mp.controlMux.Unlock()
+	return events
+}
+
+func (mp *master) unsubscribe(events chan Event) {
+	mp.controlMux.Lock()
+	delete(mp.subscribers, events)
+	mp.controlMux.Unlock()
+}
+
+//publish sends the event to streaming control clients,
+//see master.emit. slow clients miss events.
+func (mp *master) publish(e Event) {
+	mp.controlMux.Lock()
+	defer mp.controlMux.Unlock()
+	for events := range mp.subscribers {
+		select {
+		case events <- e:
+		default:
+		}
+	}
+}
+
+func (mp *master) isFetchPaused() bool {
+	mp.controlMux.Lock()
+	defer mp.controlMux.Unlock()
+	return mp.fetchPaused
+}
+
+//recordFetch keeps the result of the last fetch, see master.emit
+func (mp *master) recordFetch(e Event) {
+	switch e.Type {
+	case EventFetchNoUpdate, EventFetchFailed, EventVerifyFailed, EventSanityCheckFailed, EventBinaryReplaced:
+	default:
+		return
+	}
+	f := &FetchStatus{Time: e.Time, Result: e.Type, Hash: e.Hash}
+	if e.Err != nil {
+		f.Error = e.Err.Error()
+	}
+	mp.controlMux.Lock()
+	mp.lastFetch = f
+	mp.controlMux.Unlock()
+}
+
+func (mp *master) status() *Status {
+	now := time.Now()
+	s := &Status{
+		PID:          os.Getpid(),
+		StartedAt:    mp.startedAt,
+		Uptime:       now.Sub(mp.startedAt).Seconds(),
+		Restarting:   mp.isRestarting(),
+		ShuttingDown: mp.isShuttingDown(),
+		Fetching:     mp.fetcher != nil && mp.fetchCtx.Err() == nil,
+		Slaves:       []SlaveStatus{},
+	}
+	mp.binMux.Lock()
+	s.Hash = hex.EncodeToString(mp.binHash)
+	if mp.prevBinHash != nil {
+		s.PreviousHash = hex.EncodeToString(mp.prevBinHash)
+	}
+	mp.binMux.Unlock()
+	mp.controlMux.Lock()
+	s.FetchPaused = mp.fetchPaused
+	s.LastFetch = mp.lastFetch
+	mp.controlMux.Unlock()
+	for _, w := range mp.workers {
+		sp := w.current()
+		if sp == nil {
+			continue
+		}
+		ss := SlaveStatus{
+			ID:        sp.id,
+			Worker:    w.index,
+			Hash:      hex.EncodeToString(sp.binHash),
+			StartedAt: sp.startedAt,
+			Uptime:    now.Sub(sp.startedAt).Seconds(),
+			Ready:     sp.isReady(),
+		}
+		if sp.cmd.Process != nil {
+			ss.PID = sp.cmd.Process.Pid
+		}
+		s.Slaves = append(s.Slaves, ss)
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package overseer
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//EventType identifies the kind of Event
+type EventType string
+
+//Event types, in roughly the order they occur
+const (
+	//EventFetchStarted is emitted once the fetcher has waited for its
+	//Interval, before it looks for a new binary. Fetchers outside of
+	//package fetcher can't report this, so it's emitted once they return.
+	EventFetchStarted EventType = "fetch-started"
+	//EventFetchNoUpdate is emitted when the fetcher has no new binary,
+	//or the fetched binary is already running
+	EventFetchNoUpdate EventType = "fetch-no-update"
+	//EventFetchFailed is emitted when fetching or saving a binary fails
+	EventFetchFailed EventType = "fetch-failed"
+	//EventVerifyFailed is emitted when a fetched binary does not
+	//match its checksum or signature
+	EventVerifyFailed EventType = "verify-failed"
+	//EventSanityCheckFailed is emitted when a fetched binary is
+	//not an overseer binary
+	EventSanityCheckFailed EventType = "sanity-check-failed"
+	//EventBinaryReplaced is emitted once the fetched binary has
+	//replaced the current binary
+	EventBinaryReplaced EventType = "binary-replaced"
+	//EventRestartTriggered is emitted when a graceful restart begins
+	EventRestartTriggered EventType = "restart-triggered"
+	//EventRestartFailed is emitted when a new program
+	//doesn't become ready (see Config.WaitForReady)
+	EventRestartFailed EventType = "restart-failed"
+	//EventRestartCompleted is emitted when a graceful restart ends
+	EventRestartCompleted EventType = "restart-completed"
+	//EventRollback is emitted when the previous binary is restored
+	EventRollback EventType = "rollback"
+	//EventSlaveStarted is emitted when a program process starts
+	EventSlaveStarted EventType = "slave-started"
+	//EventSlaveReady is emitted when a program process is ready
+	EventSlaveReady EventType = "slave-ready"
+	//EventSlaveExited is emitted when a program process exits
+	EventSlaveExited EventType = "slave-exited"
+	//EventForcedKill is emitted when a program process is killed
+	//after not exiting in time
+	EventForcedKill EventType = "forced-kill"
+	//EventShutdown is emitted when a graceful shutdown begins
+	EventShutdown EventType = "shutdown"
+)
+
+//Event describes something which happened in the master process,
+//see Config.OnEvent. Fields which don't apply to the event's type
+//are left empty.
+type Event struct {
+	Type EventType
+	Time time.Time
+	//SlaveID, Worker and PID identify the program process
+	SlaveID int
+	Worker  int
+	PID     int
+	//Hash is the hex hash of the binary, see State.ID
+	Hash string
+	//PreviousHash is the hash of the binary which was replaced
+	PreviousHash string
+	//ExitCode of an exited program process
+	ExitCode int
+	//Duration of the fetch (excluding the Interval of the fetchers
+	//in package fetcher), restart, or of the program process until
+	//it exited
+	Duration time.Duration
+	//Err describes failures
+	Err error
+}
+
+//jsonEvent is Event as sent by the control socket
+type jsonEvent struct {
+	Type         EventType `json:"type"`
+	Time         time.Time `json:"time"`
+	SlaveID      int       `json:"slave_id,omitempty"`
+	Worker       int       `json:"worker"`
+	PID          int       `json:"pid,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	PreviousHash string    `json:"previous_hash,omitempty"`
+	ExitCode     int       `json:"exit_code"`
+	Duration     float64   `json:"duration,omitempty"`
+	Err          string    `json:"error,omitempty"`
+}
+
+//MarshalJSON encodes the Duration in seconds and the Err as a string
+func (e Event) MarshalJSON() ([]byte, error) {
+	j := jsonEvent{
+		Type:         e.Type,
+		Time:         e.Time,
+		SlaveID:      e.SlaveID,
+		Worker:       e.Worker,
+		PID:          e.PID,
+		Hash:         e.Hash,
+		PreviousHash: e.PreviousHash,
+		ExitCode:     e.ExitCode,
+		Duration:     e.Duration.Seconds(),
+	}
+	if e.Err != nil {
+		j.Err = e.Err.Error()
+	}
+	return json.Marshal(j)
+}
+
+//UnmarshalJSON decodes an Event encoded by MarshalJSON
+func (e *Event) UnmarshalJSON(b []byte) error {
+	j := jsonEvent{}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*e = Event{
+		Type:         j.Type,
+		Time:         j.Time,
+		SlaveID:      j.SlaveID,
+		Worker:       j.Worker,
+		PID:          j.PID,
+		Hash:         j.Hash,
+		PreviousHash: j.PreviousHash,
+		ExitCode:     j.ExitCode,
+		Duration:     time.Duration(j.Duration * float64(time.Second)),
+	}
+	if j.Err != "" {
+		e.Err = errors.New(j.Err)
+	}
+	return nil
+}
+
+func (mp *master) emit(e Event) {
+	e.Time = time.Now()
+	mp.metrics.event(e)
+	mp.recordFetch(e)
+	mp.publish(e)
+	if mp.Config.OnEvent != nil {
+		mp.Config.OnEvent(e)
+	}
+}
+
+//slaveEvent fills in the program process fields
+func slaveEvent(t EventType, s *slaveProcess) Event {
+	e := Event{
+		Type:    t,
+		SlaveID: s.id,
+		Worker:  s.worker,
+		Hash:    hex.EncodeToString(s.binHash),
+	}
+	if s.cmd.Process != nil {
+		e.PID = s.cmd.Process.Pid
+	}
+	return e
+}
@@ -1,6 +1,18 @@
 package fetcher
 
-import "io"
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
 
 // Interface defines the required fetcher functions
 type Interface interface {
@@ -18,6 +30,220 @@ type Interface interface {
 	Fetch() (io.Reader, error)
 }
 
+// ContextInterface is implemented by fetchers which
+// can be cancelled, see WithContext
+type ContextInterface interface {
+	Interface
+	//FetchContext is Fetch, except it returns ctx.Err()
+	//as soon as ctx is done, both while waiting for
+	//the next fetch and while downloading
+	FetchContext(ctx context.Context) (io.Reader, error)
+}
+
+// WithContext converts any fetcher into a ContextInterface.
+// Fetchers which don't implement ContextInterface are run
+// in a goroutine, which is abandoned once ctx is done.
+func WithContext(f Interface) ContextInterface {
+	if c, ok := f.(ContextInterface); ok {
+		return c
+	}
+	return &contextFetcher{f}
+}
+
+type contextFetcher struct {
+	Interface
+}
+
+func (f *contextFetcher) FetchContext(ctx context.Context) (io.Reader, error) {
+	type result struct {
+		r   io.Reader
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		r, err := f.Fetch()
+		done <- result{r, err}
+	}()
+	select {
+	case res := <-done:
+		return res.r, res.err
+	case <-ctx.Done():
+		//discard the result once it arrives
+		go func() {
+			if res := <-done; res.r != nil {
+				if c, ok := res.r.(io.Closer); ok {
+					c.Close()
+				}
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+//sleep for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type fetchNowKey struct{}
+
+// WithFetchNow returns a context which interrupts the wait for
+// the fetcher's next Interval whenever a value is received from
+// now, so the next fetch starts immediately. It applies to the
+// included fetchers.
+func WithFetchNow(ctx context.Context, now <-chan bool) context.Context {
+	return context.WithValue(ctx, fetchNowKey{}, now)
+}
+
+//waitInterval is sleep, except it ends early on WithFetchNow
+func waitInterval(ctx context.Context, d time.Duration) error {
+	now, _ := ctx.Value(fetchNowKey{}).(<-chan bool)
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-now:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type startedKey struct{}
+
+// WithStarted returns a context which the included fetchers
+// call fn with once they have waited for their Interval, just
+// before they look for a new binary, so the fetch can be timed.
+func WithStarted(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, startedKey{}, fn)
+}
+
+//started calls the WithStarted func of ctx
+func started(ctx context.Context) {
+	if fn, ok := ctx.Value(startedKey{}).(func()); ok {
+		fn()
+	}
+}
+
+// Logger receives fetcher logs, it is satisfied
+// by overseer.Logger and *slog.Logger
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// LoggerSetter is optionally implemented by fetchers which log.
+// When overseer.Config.Logger is set, it is passed to SetLogger
+// before Init is called.
+type LoggerSetter interface {
+	SetLogger(l Logger)
+}
+
+// SignatureFetcher is optionally implemented by fetchers
+// which publish detached signatures alongside their binaries
+// (see overseer.Config.PublicKeys)
+type SignatureFetcher interface {
+	//FetchSignature returns the signature of the
+	//binary most recently returned by Fetch, until
+	//ctx is done
+	FetchSignature(ctx context.Context) ([]byte, error)
+}
+
+//signatures are small, anything larger is not a signature
+const maxSignatureSize = 4096
+
+func readSignature(r io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxSignatureSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSignatureSize {
+		return nil, fmt.Errorf("signature too large")
+	}
+	return b, nil
+}
+
+// ChecksumFetcher is optionally implemented by fetchers
+// which can download a checksum manifest (such as the output
+// of sha256sum) published alongside their binaries
+type ChecksumFetcher interface {
+	//FetchChecksum returns the SHA-256 digest of the file
+	//most recently downloaded by Fetch, as listed in the
+	//manifest, until ctx is done. A nil digest means no
+	//manifest is configured.
+	FetchChecksum(ctx context.Context) ([]byte, error)
+}
+
+// Digester is implemented by the readers of the included fetchers
+// when they extract the binary (such as from a ".gz" file). Digest
+// returns the SHA-256 digest of the file as it was downloaded, which
+// is what checksum manifests list. It is only valid once the binary
+// has been read to the end.
+type Digester interface {
+	Digest() []byte
+}
+
+//gzipReader extracts the downloaded file, while hashing it
+type gzipReader struct {
+	*gzip.Reader
+	body io.ReadCloser
+	hash hash.Hash
+}
+
+func newGzipReader(body io.ReadCloser) (io.Reader, error) {
+	g := &gzipReader{body: body, hash: sha256.New()}
+	z, err := gzip.NewReader(io.TeeReader(body, g.hash))
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	g.Reader = z
+	return g, nil
+}
+
+func (g *gzipReader) Digest() []byte {
+	return g.hash.Sum(nil)
+}
+
+func (g *gzipReader) Close() error {
+	g.Reader.Close()
+	return g.body.Close()
+}
+
+//findChecksum finds the digest of the named file in a
+//"<hex digest>  <name>" manifest. gzipped files are listed
+//by their own name, see Digester.
+func findChecksum(r io.Reader, name string) ([]byte, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		//binary mode entries are prefixed with "*"
+		if strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid checksum for %s", name)
+		}
+		return sum, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no checksum for %s", name)
+}
+
 // Func converts a fetch function into the fetcher interface
 func Func(fn func() (io.Reader, error)) Interface {
 	return &fetcher{fn}
@@ -1,6 +1,7 @@
 package fetcher
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -36,11 +37,19 @@ func (f *File) Init() error {
 
 // Fetch file from the specified Path
 func (f *File) Fetch() (io.Reader, error) {
+	return f.FetchContext(context.Background())
+}
+
+// FetchContext fetches the file from the specified Path, until ctx is done
+func (f *File) FetchContext(ctx context.Context) (io.Reader, error) {
 	//only delay after first fetch
 	if f.delay {
-		time.Sleep(f.Interval)
+		if err := waitInterval(ctx, f.Interval); err != nil {
+			return nil, err
+		}
 	}
 	f.delay = true
+	started(ctx)
 	lastHash := f.hash
 	if err := f.updateHash(); err != nil {
 		return nil, err
@@ -66,7 +75,10 @@ func (f *File) Fetch() (io.Reader, error) {
 		}
 		attempt++
 		//sleep
-		time.Sleep(rate)
+		if err := sleep(ctx, rate); err != nil {
+			file.Close()
+			return nil, err
+		}
 		//check hash!
 		if err := f.updateHash(); err != nil {
 			file.Close()
@@ -78,7 +90,20 @@ func (f *File) Fetch() (io.Reader, error) {
 		}
 		lastHash = f.hash
 	}
-	return file, nil
+	return &contextReader{ctx: ctx, ReadCloser: file}, nil
+}
+
+//contextReader stops reading once ctx is done
+type contextReader struct {
+	ctx context.Context
+	io.ReadCloser
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadCloser.Read(p)
 }
 
 func (f *File) updateHash() error {
@@ -98,3 +123,13 @@ func (f *File) updateHash() error {
 	f.hash = fmt.Sprintf("%d|%d", s.ModTime().UnixNano(), s.Size())
 	return nil
 }
+
+// FetchSignature from the specified Path + ".sig"
+func (f *File) FetchSignature(ctx context.Context) ([]byte, error) {
+	file, err := os.Open(f.Path + ".sig")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readSignature(file)
+}
@@ -1,7 +1,7 @@
 package fetcher
 
 import (
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,10 +23,16 @@ type Github struct {
 	Interval time.Duration
 	//Asset is used to find matching release asset.
 	//By default a file will match if it contains
-	//both GOOS and GOARCH.
+	//both GOOS and GOARCH. Signatures (".sig") are
+	//never matched.
 	Asset func(filename string) bool
+	//ChecksumAsset is the name of an optional checksum
+	//manifest in the same release (e.g. "SHA256SUMS")
+	ChecksumAsset string
 	//internal state
+	logger        Logger
 	releaseURL    string
+	assetName     string
 	delay         bool
 	lastETag      string
 	latestRelease struct {
@@ -58,20 +64,46 @@ func (h *Github) Init() error {
 	if h.Interval == 0 {
 		h.Interval = 5 * time.Minute
 	} else if h.Interval < 1*time.Minute {
-		log.Printf("[overseer.github] warning: intervals less than 1 minute will surpass the public rate limit")
+		h.warnf("intervals less than 1 minute will surpass the public rate limit")
 	}
 	return nil
 }
 
+// SetLogger replaces the standard logger
+func (h *Github) SetLogger(l Logger) {
+	h.logger = l
+}
+
+func (h *Github) warnf(f string, args ...interface{}) {
+	if h.logger != nil {
+		h.logger.Warn(fmt.Sprintf(f, args...), "fetcher", "github", "user", h.User, "repo", h.Repo)
+	} else {
+		log.Printf("[overseer.github] warning: "+f, args...)
+	}
+}
+
 // Fetch the binary from the provided Repository
 func (h *Github) Fetch() (io.Reader, error) {
+	return h.FetchContext(context.Background())
+}
+
+// FetchContext fetches the binary from the provided Repository, until ctx is done
+func (h *Github) FetchContext(ctx context.Context) (io.Reader, error) {
 	//delay fetches after first
 	if h.delay {
-		time.Sleep(h.Interval)
+		if err := waitInterval(ctx, h.Interval); err != nil {
+			return nil, err
+		}
 	}
 	h.delay = true
+	started(ctx)
 	//check release status
-	resp, err := http.Get(h.releaseURL)
+	req, err := http.NewRequest("GET", h.releaseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("release info request failed (%s)", err)
 	}
@@ -88,7 +120,11 @@ func (h *Github) Fetch() (io.Reader, error) {
 	//find appropriate asset
 	assetURL := ""
 	for _, a := range h.latestRelease.Assets {
+		if strings.HasSuffix(a.Name, ".sig") || a.Name == h.ChecksumAsset {
+			continue
+		}
 		if h.Asset(a.Name) {
+			h.assetName = a.Name
 			assetURL = a.URL
 			break
 		}
@@ -97,7 +133,8 @@ func (h *Github) Fetch() (io.Reader, error) {
 		return nil, fmt.Errorf("no matching assets in this release (%s)", h.latestRelease.TagName)
 	}
 	//fetch location
-	req, _ := http.NewRequest("HEAD", assetURL, nil)
+	req, _ = http.NewRequest("HEAD", assetURL, nil)
+	req = req.WithContext(ctx)
 	resp, err = http.DefaultTransport.RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("release location request failed (%s)", err)
@@ -112,6 +149,7 @@ func (h *Github) Fetch() (io.Reader, error) {
 	if err != nil {
 		return nil, fmt.Errorf("release location url error (%s)", err)
 	}
+	req = req.WithContext(ctx)
 	req.Header.Set("Range", "bytes=0-0") // HEAD not allowed so we request for 1 byte
 	resp, err = http.DefaultTransport.RoundTrip(req)
 	if err != nil {
@@ -126,7 +164,12 @@ func (h *Github) Fetch() (io.Reader, error) {
 		return nil, nil //skip, hash match
 	}
 	//get binary request
-	resp, err = http.Get(s3URL)
+	req, err = http.NewRequest("GET", s3URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("release location url error (%s)", err)
+	}
+	req = req.WithContext(ctx)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("release binary request failed (%s)", err)
 	}
@@ -138,7 +181,66 @@ func (h *Github) Fetch() (io.Reader, error) {
 	//success!
 	//extract gz files
 	if strings.HasSuffix(assetURL, ".gz") && resp.Header.Get("Content-Encoding") != "gzip" {
-		return gzip.NewReader(resp.Body)
+		return newGzipReader(resp.Body)
 	}
 	return resp.Body, nil
 }
+
+// FetchSignature of the binary from the same release,
+// the signature asset is the binary's asset name + ".sig"
+func (h *Github) FetchSignature(ctx context.Context) ([]byte, error) {
+	sigURL := ""
+	for _, a := range h.latestRelease.Assets {
+		if a.Name == h.assetName+".sig" {
+			sigURL = a.URL
+			break
+		}
+	}
+	if sigURL == "" {
+		return nil, fmt.Errorf("no signature for %s in this release (%s)", h.assetName, h.latestRelease.TagName)
+	}
+	req, err := http.NewRequest("GET", sigURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("release signature request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release signature request failed (status code %d)", resp.StatusCode)
+	}
+	return readSignature(resp.Body)
+}
+
+// FetchChecksum of the binary from the ChecksumAsset
+// manifest in the same release
+func (h *Github) FetchChecksum(ctx context.Context) ([]byte, error) {
+	if h.ChecksumAsset == "" {
+		return nil, nil
+	}
+	sumsURL := ""
+	for _, a := range h.latestRelease.Assets {
+		if a.Name == h.ChecksumAsset {
+			sumsURL = a.URL
+			break
+		}
+	}
+	if sumsURL == "" {
+		return nil, fmt.Errorf("no %s in this release (%s)", h.ChecksumAsset, h.latestRelease.TagName)
+	}
+	req, err := http.NewRequest("GET", sumsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("release checksum request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("release checksum request failed (status code %d)", resp.StatusCode)
+	}
+	return findChecksum(resp.Body, h.assetName)
+}
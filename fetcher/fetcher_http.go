@@ -1,10 +1,12 @@
 package fetcher
 
 import (
-	"compress/gzip"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 )
@@ -17,6 +19,11 @@ type HTTP struct {
 	URL          string
 	Interval     time.Duration
 	CheckHeaders []string
+	//SignatureURL of the binary's signature, defaults to URL + ".sig"
+	SignatureURL string
+	//ChecksumURL of an optional checksum manifest (e.g. SHA256SUMS)
+	//which lists the binary by the file name in URL
+	ChecksumURL string
 	//internal state
 	delay bool
 	lasts map[string]string
@@ -38,18 +45,34 @@ func (h *HTTP) Init() error {
 	if h.CheckHeaders == nil {
 		h.CheckHeaders = defaultHTTPCheckHeaders
 	}
+	if h.SignatureURL == "" {
+		h.SignatureURL = h.URL + ".sig"
+	}
 	return nil
 }
 
 // Fetch the binary from the provided URL
 func (h *HTTP) Fetch() (io.Reader, error) {
+	return h.FetchContext(context.Background())
+}
+
+// FetchContext fetches the binary from the provided URL, until ctx is done
+func (h *HTTP) FetchContext(ctx context.Context) (io.Reader, error) {
 	//delay fetches after first
 	if h.delay {
-		time.Sleep(h.Interval)
+		if err := waitInterval(ctx, h.Interval); err != nil {
+			return nil, err
+		}
 	}
 	h.delay = true
+	started(ctx)
 	//status check using HEAD
-	resp, err := http.Head(h.URL)
+	req, err := http.NewRequest("HEAD", h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("HEAD request failed (%s)", err)
 	}
@@ -72,7 +95,12 @@ func (h *HTTP) Fetch() (io.Reader, error) {
 		return nil, nil //skip, file match
 	}
 	//binary fetch using GET
-	resp, err = http.Get(h.URL)
+	req, err = http.NewRequest("GET", h.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	resp, err = http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("GET request failed (%s)", err)
 	}
@@ -81,8 +109,49 @@ func (h *HTTP) Fetch() (io.Reader, error) {
 	}
 	//extract gz files
 	if strings.HasSuffix(h.URL, ".gz") && resp.Header.Get("Content-Encoding") != "gzip" {
-		return gzip.NewReader(resp.Body)
+		return newGzipReader(resp.Body)
 	}
 	//success!
 	return resp.Body, nil
 }
+
+// FetchSignature of the binary from the SignatureURL
+func (h *HTTP) FetchSignature(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequest("GET", h.SignatureURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("GET signature request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET signature request failed (status code %d)", resp.StatusCode)
+	}
+	return readSignature(resp.Body)
+}
+
+// FetchChecksum of the binary from the ChecksumURL manifest
+func (h *HTTP) FetchChecksum(ctx context.Context) ([]byte, error) {
+	if h.ChecksumURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", h.ChecksumURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("GET checksum request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET checksum request failed (status code %d)", resp.StatusCode)
+	}
+	return findChecksum(resp.Body, path.Base(u.Path))
+}
@@ -1,7 +1,7 @@
 package fetcher
 
 import (
-	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -27,6 +28,11 @@ type S3 struct {
 	Region string
 	Bucket string
 	Key    string
+	//SignatureKey of the binary's signature, defaults to Key + ".sig"
+	SignatureKey string
+	//ChecksumKey of an optional checksum manifest (e.g. SHA256SUMS)
+	//which lists the binary by the file name in Key
+	ChecksumKey string
 	//Interval between checks
 	Interval time.Duration
 	//HeadTimeout defaults to 5 seconds
@@ -49,6 +55,9 @@ func (s *S3) Init() error {
 	if s.Region == "" {
 		s.Region = "ap-southeast-2"
 	}
+	if s.SignatureKey == "" {
+		s.SignatureKey = s.Key + ".sig"
+	}
 	//initial etag
 	if p, _ := os.Executable(); p != "" {
 		if f, err := os.Open(p); err == nil {
@@ -73,24 +82,29 @@ func (s *S3) Init() error {
 
 // Fetch the binary from S3
 func (s *S3) Fetch() (io.Reader, error) {
+	return s.FetchContext(context.Background())
+}
+
+// FetchContext fetches the binary from S3, until ctx is done
+func (s *S3) FetchContext(ctx context.Context) (io.Reader, error) {
 	//delay fetches after first
 	if s.delay {
-		time.Sleep(s.Interval)
+		if err := waitInterval(ctx, s.Interval); err != nil {
+			return nil, err
+		}
 	}
 	s.delay = true
+	started(ctx)
 	//http client where we change the timeout
 	c := http.Client{}
 	//options for this key
-	creds := s3.AmbientCredentials()
-	if s.Access != "" && s.Secret != "" {
-		creds = s3.Credentials(s.Access, s.Secret)
-	}
-	opts := []s3.Option{creds, s3.Region(s.Region), s3.Bucket(s.Bucket), s3.Key(s.Key)}
+	opts := s.options(s.Key)
 	//status check using HEAD
 	req, err := s3.NewRequest("HEAD", opts...)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	c.Timeout = s.HeadTimeout
 	resp, err := c.Do(req)
 	if err != nil {
@@ -109,6 +123,7 @@ func (s *S3) Fetch() (io.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	c.Timeout = s.GetTimeout
 	resp, err = c.Do(req)
 	if err != nil {
@@ -119,8 +134,57 @@ func (s *S3) Fetch() (io.Reader, error) {
 	}
 	//extract gz files
 	if strings.HasSuffix(s.Key, ".gz") && resp.Header.Get("Content-Encoding") != "gzip" {
-		return gzip.NewReader(resp.Body)
+		return newGzipReader(resp.Body)
 	}
 	//success!
 	return resp.Body, nil
 }
+
+// FetchSignature of the binary from S3
+func (s *S3) FetchSignature(ctx context.Context) ([]byte, error) {
+	req, err := s3.NewRequest("GET", s.options(s.SignatureKey)...)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	c := http.Client{Timeout: s.HeadTimeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET signature request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET signature request failed (%s)", resp.Status)
+	}
+	return readSignature(resp.Body)
+}
+
+// FetchChecksum of the binary from the ChecksumKey manifest
+func (s *S3) FetchChecksum(ctx context.Context) ([]byte, error) {
+	if s.ChecksumKey == "" {
+		return nil, nil
+	}
+	req, err := s3.NewRequest("GET", s.options(s.ChecksumKey)...)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	c := http.Client{Timeout: s.HeadTimeout}
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GET checksum request failed (%s)", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET checksum request failed (%s)", resp.Status)
+	}
+	return findChecksum(resp.Body, path.Base(s.Key))
+}
+
+func (s *S3) options(key string) []s3.Option {
+	creds := s3.AmbientCredentials()
+	if s.Access != "" && s.Secret != "" {
+		creds = s3.Credentials(s.Access, s.Secret)
+	}
+	return []s3.Option{creds, s3.Region(s.Region), s3.Bucket(s.Bucket), s3.Key(key)}
+}
@@ -0,0 +1,117 @@
+package fetcher
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("app"))
+	hexSum := hex.EncodeToString(sum[:])
+	for _, test := range []struct {
+		name, manifest, file string
+		err                  bool
+	}{
+		{"text mode", hexSum + "  app\n", "app", false},
+		{"binary mode", hexSum + " *app\n", "app", false},
+		{"among others", strings.Repeat("0", 64) + "  other\n" + hexSum + "  app\n", "app", false},
+		{"gzipped by own name", hexSum + "  app.gz\n", "app.gz", false},
+		{"not extracted name", hexSum + "  app\n", "app.gz", true},
+		{"missing", hexSum + "  other\n", "app", true},
+		{"short digest", "abcd  app\n", "app", true},
+		{"not hex", strings.Repeat("z", 64) + "  app\n", "app", true},
+		{"malformed line", hexSum + "  app extra\n", "app", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := findChecksum(strings.NewReader(test.manifest), test.file)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %x", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, sum[:]) {
+				t.Fatalf("expected %x, got %x", sum, got)
+			}
+		})
+	}
+}
+
+func TestGzipDigest(t *testing.T) {
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	w.Write([]byte("binary"))
+	w.Close()
+	sum := sha256.Sum256(gz.Bytes())
+	r, err := newGzipReader(ioutil.NopCloser(bytes.NewReader(gz.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "binary" {
+		t.Fatalf("expected the extracted binary, got %q", b)
+	}
+	if d := r.(Digester).Digest(); !bytes.Equal(d, sum[:]) {
+		t.Fatalf("expected the digest of the download %x, got %x", sum, d)
+	}
+}
+
+func TestWithStarted(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "overseer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	f := &File{Path: tmp.Name(), Interval: time.Minute}
+	if err := f.Init(); err != nil {
+		t.Fatal(err)
+	}
+	var startedAt []time.Time
+	ctx, cancel := context.WithCancel(WithStarted(context.Background(), func() {
+		startedAt = append(startedAt, time.Now())
+	}))
+	now := make(chan bool, 1)
+	ctx = WithFetchNow(ctx, now)
+	//the first fetch doesn't wait
+	if _, err := f.FetchContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(startedAt) != 1 {
+		t.Fatalf("expected 1 start, got %d", len(startedAt))
+	}
+	//later fetches start after the wait
+	t0 := time.Now()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		now <- true
+	}()
+	if _, err := f.FetchContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(startedAt) != 2 || startedAt[1].Sub(t0) < 50*time.Millisecond {
+		t.Fatalf("expected a start after the wait, got %v", startedAt)
+	}
+	//cancelled fetches don't start
+	cancel()
+	if _, err := f.FetchContext(ctx); err != context.Canceled {
+		t.Fatalf("expected a cancelled fetch, got %v", err)
+	}
+	if len(startedAt) != 2 {
+		t.Fatalf("expected 2 starts, got %d", len(startedAt))
+	}
+}
@@ -5,37 +5,79 @@ package overseer
 //have been closed
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func newOverseerListener(l net.Listener) *overseerListener {
-	return &overseerListener{
+//DefaultKeepAlive is the keep-alive period of TCP
+//connections accepted by a Listener (see NewListener)
+const DefaultKeepAlive = 3 * time.Minute
+
+//NewListener wraps l with the drain tracking used for
+//State.Listeners, so programs can gracefully drain listeners
+//of their own, such as a TLS listener or one they bound
+//themselves. Call Drain once GracefulShutdown is closed, and
+//Wait for the remaining connections before returning.
+func NewListener(l net.Listener) *Listener {
+	return &Listener{
 		Listener:     l,
+		KeepAlive:    DefaultKeepAlive,
 		closeByForce: make(chan bool),
 	}
 }
 
-//gracefully closing net.Listener
-type overseerListener struct {
+//Listener is a gracefully closing net.Listener, which tracks
+//the connections it has accepted. It wraps any net.Listener.
+type Listener struct {
 	net.Listener
-	closeError   error
-	closeByForce chan bool
-	wg           sync.WaitGroup
+	//KeepAlive is the keep-alive period of accepted connections
+	//which support it (such as *net.TCPConn), 0 leaves them
+	//unchanged, and a negative value disables keep-alives.
+	//Set it before the first Accept.
+	KeepAlive time.Duration
+	//ProxyProtocol, when set, parses PROXY protocol headers
+	//of accepted connections. Set it before the first Accept.
+	ProxyProtocol *ProxyProtocol
+	closeOnce     sync.Once
+	closeError    error
+	closeByForce  chan bool
+	forceOnce     sync.Once
+	wg            sync.WaitGroup
+	//connection stats
+	active, forced int64
+}
+
+//keepAliver is implemented by *net.TCPConn
+type keepAliver interface {
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
 }
 
-func (l *overseerListener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.(*net.TCPListener).AcceptTCP()
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
 	if err != nil {
 		return nil, err
 	}
-	conn.SetKeepAlive(true)                  // see http.tcpKeepAliveListener
-	conn.SetKeepAlivePeriod(3 * time.Minute) // see http.tcpKeepAliveListener
+	if ka, ok := conn.(keepAliver); ok && l.KeepAlive != 0 {
+		if l.KeepAlive < 0 {
+			ka.SetKeepAlive(false)
+		} else {
+			ka.SetKeepAlive(true)
+			ka.SetKeepAlivePeriod(l.KeepAlive)
+		}
+	}
+	if p := l.ProxyProtocol; p != nil && p.trusts(conn.RemoteAddr()) {
+		conn = newProxyConn(conn, p)
+	}
 	uconn := overseerConn{
 		Conn:   conn,
 		wg:     &l.wg,
+		active: &l.active,
 		closed: make(chan bool),
 	}
 	go func() {
@@ -48,13 +90,15 @@ func (l *overseerListener) Accept() (net.Conn, error) {
 		}
 	}()
 	l.wg.Add(1)
+	atomic.AddInt64(&l.active, 1)
 	return uconn, nil
 }
 
-//non-blocking trigger close
-func (l *overseerListener) release(timeout time.Duration) {
+//Drain stops accepting connections, without blocking. Once
+//timeout has passed, the remaining connections are closed.
+func (l *Listener) Drain(timeout time.Duration) {
 	//stop accepting connections - release fd
-	l.closeError = l.Listener.Close()
+	l.Close()
 	//start timer, close by force if deadline not met
 	waited := make(chan bool)
 	go func() {
@@ -64,38 +108,127 @@ func (l *overseerListener) release(timeout time.Duration) {
 	go func() {
 		select {
 		case <-time.After(timeout):
-			close(l.closeByForce)
+			l.ForceClose()
 		case <-waited:
 			//no need to force close
 		}
 	}()
 }
 
-//blocking wait for close
-func (l *overseerListener) Close() error {
-	l.wg.Wait()
+//ForceClose closes all remaining connections
+func (l *Listener) ForceClose() {
+	l.forceOnce.Do(func() {
+		atomic.AddInt64(&l.forced, atomic.LoadInt64(&l.active))
+		close(l.closeByForce)
+	})
+}
+
+//Close stops accepting connections, without closing or
+//waiting for the accepted ones. Only the first call closes
+//the wrapped listener, later calls return the same error.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeError = l.Listener.Close()
+	})
 	return l.closeError
 }
 
-func (l *overseerListener) File() *os.File {
-	// returns a dup(2) - FD_CLOEXEC flag *not* set
-	tl := l.Listener.(*net.TCPListener)
-	fl, _ := tl.File()
-	return fl
+//Wait blocks until all accepted connections have been closed,
+//use it after Drain (or Close) to let them finish
+func (l *Listener) Wait() {
+	l.wg.Wait()
+}
+
+//Active returns the number of open connections
+func (l *Listener) Active() int {
+	return int(atomic.LoadInt64(&l.active))
+}
+
+//File returns a dup(2) of the listener's descriptor, with the
+//FD_CLOEXEC flag *not* set. Not all listeners have one.
+func (l *Listener) File() (*os.File, error) {
+	fl, ok := l.Listener.(filer)
+	if !ok {
+		return nil, fmt.Errorf("overseer: %T has no file descriptor", l.Listener)
+	}
+	return fl.File()
+}
+
+//filer is implemented by *net.TCPListener and *net.UnixListener
+type filer interface {
+	File() (*os.File, error)
 }
 
 //notifying on close net.Conn
 type overseerConn struct {
 	net.Conn
 	wg     *sync.WaitGroup
+	active *int64
 	closed chan bool
 }
 
 func (o overseerConn) Close() error {
 	err := o.Conn.Close()
 	if err == nil {
+		atomic.AddInt64(o.active, -1)
 		o.wg.Done()
 		o.closed <- true
 	}
 	return err
 }
+
+//ErrPacketConnReleased is returned by State.PacketConns reads
+//once a graceful shutdown has begun
+var ErrPacketConnReleased = errors.New("overseer: packet conn released")
+
+func newOverseerPacketConn(c net.PacketConn) *overseerPacketConn {
+	return &overseerPacketConn{PacketConn: c}
+}
+
+//packet conns have no connections to track, so releasing
+//a packet conn stops reading while still allowing replies
+type overseerPacketConn struct {
+	net.PacketConn
+	mut      sync.Mutex
+	released bool
+}
+
+func (c *overseerPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.isReleased() {
+		return 0, nil, ErrPacketConnReleased
+	}
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil && c.isReleased() {
+		//unblocked by release
+		return n, addr, ErrPacketConnReleased
+	}
+	return n, addr, err
+}
+
+func (c *overseerPacketConn) SetDeadline(t time.Time) error {
+	if c.isReleased() {
+		return c.PacketConn.SetWriteDeadline(t)
+	}
+	return c.PacketConn.SetDeadline(t)
+}
+
+func (c *overseerPacketConn) SetReadDeadline(t time.Time) error {
+	if c.isReleased() {
+		return nil
+	}
+	return c.PacketConn.SetReadDeadline(t)
+}
+
+//non-blocking stop reading, unblocks any pending reads
+func (c *overseerPacketConn) release() {
+	c.mut.Lock()
+	c.released = true
+	c.mut.Unlock()
+	c.PacketConn.SetReadDeadline(time.Unix(1, 0))
+}
+
+func (c *overseerPacketConn) isReleased() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.released
+}
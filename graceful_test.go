@@ -0,0 +1,110 @@
+package overseer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerCloseWait(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(tl)
+	accepted := make(chan net.Conn)
+	acceptErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			accepted <- conn
+		}
+	}()
+	client, err := net.Dial("tcp", tl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	conn := <-accepted
+	//close stops accepting, without waiting for conn
+	closed := make(chan error)
+	go func() { closed <- l.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close blocked by an open connection")
+	}
+	select {
+	case <-acceptErr:
+	case <-time.After(5 * time.Second):
+		t.Fatal("accept not unblocked by close")
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("expected the first close's error, got %s", err)
+	}
+	if l.Active() != 1 {
+		t.Fatalf("expected 1 active connection, got %d", l.Active())
+	}
+	//drain after close, then wait for conn
+	l.Drain(time.Minute)
+	waited := make(chan bool)
+	go func() {
+		l.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("wait returned with an open connection")
+	case <-time.After(50 * time.Millisecond):
+	}
+	conn.Close()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait blocked after the connection closed")
+	}
+}
+
+func TestListenerDrainForce(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(tl)
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	client, err := net.Dial("tcp", tl.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for l.Active() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.Drain(50 * time.Millisecond)
+	waited := make(chan bool)
+	go func() {
+		l.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed by force")
+	}
+	if l.forced != 1 {
+		t.Fatalf("expected 1 forced close, got %d", l.forced)
+	}
+}
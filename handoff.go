@@ -0,0 +1,202 @@
+package overseer
+
+//programs can hand state over to the next program of their
+//worker. the old program writes into State.Handoff, a temp
+//file, and reports back when it's closed. the master process
+//reads the file, and writes it into the new program's inherited
+//pipe, which is read through State.Inherited.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	envHandoffPath = "OVERSEER_HANDOFF_PATH"
+	envInheritedFD = "OVERSEER_INHERITED_FD"
+)
+
+//ErrHandoffTooLarge is returned by State.Handoff
+//once more than Config.HandoffLimit is written
+var ErrHandoffTooLarge = errors.New("overseer: handoff too large")
+
+//handoffPath of a new slave process
+func handoffPath() string {
+	return filepath.Join(os.TempDir(), "overseer-handoff-"+token())
+}
+
+//handoff is run in a goroutine for each new slave process,
+//it passes the handoff of the previous slave into the pipe
+func (mp *master) handoff(prev, s *slaveProcess, pipe *os.File) {
+	defer mp.handoffs.Done()
+	defer pipe.Close()
+	if prev == nil {
+		return
+	}
+	l := mp.slaveLog(s)
+	//the timeout starts once the previous slave is asked to
+	//shut down, which only happens after a cutover is ready
+	select {
+	case <-prev.drain:
+	case <-prev.exited:
+	case <-s.exited:
+		return
+	}
+	select {
+	case <-prev.handedOff:
+	case <-prev.exited:
+	case <-s.exited:
+		return
+	case <-time.After(mp.HandoffTimeout):
+		l.warnf("slave#%d handoff timed out after %s", prev.id, mp.HandoffTimeout)
+		return
+	}
+	//the handoff may arrive just before the exit
+	select {
+	case <-prev.handedOff:
+	default:
+		l.debugf("slave#%d exited without a handoff", prev.id)
+		return
+	}
+	if len(prev.handoffData) == 0 {
+		return
+	}
+	//the new slave may never read all of it, the
+	//write is then abandoned and the pipe closed
+	written := make(chan error, 1)
+	go func() {
+		_, err := pipe.Write(prev.handoffData)
+		written <- err
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			l.debugf("handoff to slave#%d failed (%s)", s.id, err)
+			return
+		}
+	case <-s.exited:
+		l.debugf("slave#%d exited before reading the handoff", s.id)
+		return
+	case <-time.After(mp.HandoffTimeout):
+		l.warnf("handoff to slave#%d timed out after %s", s.id, mp.HandoffTimeout)
+		return
+	}
+	l.debugf("handed off %d bytes from slave#%d to slave#%d", len(prev.handoffData), prev.id, s.id)
+}
+
+//waitHandoffs waits at most d for the handoffs to the new
+//slaves, and returns whether they have all completed
+func (mp *master) waitHandoffs(d time.Duration) bool {
+	done := make(chan bool)
+	go func() {
+		mp.handoffs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+//handedOff handles a handoff message from a slave
+func (mp *master) handedOff(s *slaveProcess) {
+	s.handoffOnce.Do(func() {
+		defer close(s.handedOff)
+		defer os.Remove(s.handoffPath)
+		f, err := os.Open(s.handoffPath)
+		if os.IsNotExist(err) {
+			//nothing was written
+			return
+		} else if err != nil {
+			mp.slaveLog(s).warnf("slave#%d handoff failed (%s)", s.id, err)
+			return
+		}
+		defer f.Close()
+		b, err := ioutil.ReadAll(io.LimitReader(f, mp.HandoffLimit+1))
+		if err != nil {
+			mp.slaveLog(s).warnf("slave#%d handoff failed (%s)", s.id, err)
+			return
+		} else if int64(len(b)) > mp.HandoffLimit {
+			mp.slaveLog(s).warnf("slave#%d handoff failed (%s)", s.id, ErrHandoffTooLarge)
+			return
+		}
+		s.handoffData = b
+	})
+}
+
+//handoffWriter is the State.Handoff of a slave process
+type handoffWriter struct {
+	sp     *slave
+	path   string
+	limit  int64
+	mut    sync.Mutex
+	f      *os.File
+	n      int64
+	closed bool
+}
+
+func (h *handoffWriter) Write(p []byte) (int, error) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.path == "" {
+		return 0, errors.New("overseer: handoff not supported")
+	} else if h.closed {
+		return 0, os.ErrClosed
+	}
+	if h.f == nil {
+		f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("overseer: handoff failed (%s)", err)
+		}
+		h.f = f
+	}
+	if h.n+int64(len(p)) > h.limit {
+		return 0, ErrHandoffTooLarge
+	}
+	n, err := h.f.Write(p)
+	h.n += int64(n)
+	return n, err
+}
+
+//Close completes the handoff, the next
+//program can't read it until then
+func (h *handoffWriter) Close() error {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.closed {
+		return nil
+	}
+	h.closed = true
+	var err error
+	if h.f != nil {
+		err = h.f.Close()
+	}
+	if h.path != "" {
+		h.sp.send(msgHandoff)
+	}
+	return err
+}
+
+//initHandoff sets up State.Handoff and State.Inherited
+func (sp *slave) initHandoff() {
+	h := &handoffWriter{sp: sp, limit: sp.HandoffLimit}
+	if sp.pipe != nil {
+		h.path = os.Getenv(envHandoffPath)
+	}
+	sp.handoff = h
+	sp.state.Handoff = h
+	sp.state.Inherited = bytes.NewReader(nil)
+	if fd, err := strconv.Atoi(os.Getenv(envInheritedFD)); err == nil {
+		sp.state.Inherited = os.NewFile(uintptr(fd), "overseer-inherited")
+	}
+}
@@ -0,0 +1,76 @@
+// +build linux darwin freebsd
+
+package overseer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHandoffUnread(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		exit bool
+	}{
+		{"timeout", false},
+		{"exited", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mp := &master{Config: &Config{HandoffTimeout: 100 * time.Millisecond}}
+			if test.exit {
+				mp.HandoffTimeout = time.Minute
+			}
+			prev := newSlaveProcess(1, 0, nil, nil)
+			prev.handoffData = bytes.Repeat([]byte("x"), 1<<20)
+			close(prev.drain)
+			close(prev.handedOff)
+			s := newSlaveProcess(2, 0, nil, nil)
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			//the new slave never reads all of it
+			if test.exit {
+				go func() {
+					r.Read(make([]byte, 1024))
+					close(s.exited)
+				}()
+			}
+			mp.handoffs.Add(1)
+			go mp.handoff(prev, s, w)
+			if !mp.waitHandoffs(5 * time.Second) {
+				t.Fatal("handoff blocked by the unread pipe")
+			}
+		})
+	}
+}
+
+func TestHandoff(t *testing.T) {
+	mp := &master{Config: &Config{HandoffTimeout: 5 * time.Second}}
+	prev := newSlaveProcess(1, 0, nil, nil)
+	prev.handoffData = bytes.Repeat([]byte("x"), 1<<20)
+	close(prev.drain)
+	close(prev.handedOff)
+	s := newSlaveProcess(2, 0, nil, nil)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	mp.handoffs.Add(1)
+	go mp.handoff(prev, s, w)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, prev.handoffData) {
+		t.Fatalf("expected %d bytes, read %d", len(prev.handoffData), len(b))
+	}
+	if !mp.waitHandoffs(5 * time.Second) {
+		t.Fatal("handoff not completed")
+	}
+}
@@ -0,0 +1,72 @@
+package overseer
+
+//slave processes report back to the master process by
+//writing newline delimited messages into an inherited pipe.
+//pipes are only passed where Cmd.ExtraFiles is supported.
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	//program is serving
+	msgReady = "ready"
+	//program is alive, sent every heartbeat
+	msgAlive = "alive"
+	//program has released its sockets during a restart
+	msgReleased = "released"
+	//program requested a graceful shutdown, with an optional timeout
+	msgShutdown = "shutdown"
+	//program's connection stats, sent when metrics are enabled
+	msgStats = "stats"
+	//program has closed its State.Handoff
+	msgHandoff = "handoff"
+)
+
+//openPipe creates the message pipe for a new slave, returning the
+//read end for the master and the write end to be inherited
+func openPipe() (r, w *os.File, err error) {
+	if !pipesSupported {
+		return nil, nil, nil
+	}
+	return os.Pipe()
+}
+
+//readPipe is run in a goroutine for each slave process
+func (mp *master) readPipe(s *slaveProcess, r *os.File) {
+	defer close(s.pipeClosed)
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		msg := scanner.Text()
+		args := ""
+		if i := strings.Index(msg, " "); i != -1 {
+			msg, args = msg[:i], msg[i+1:]
+		}
+		mp.handleMessage(s, msg, args)
+	}
+}
+
+//inheritPipe opens the slave's end of the message pipe, if any
+func (sp *slave) inheritPipe() {
+	fd, err := strconv.Atoi(os.Getenv(envPipeFD))
+	if err != nil {
+		return
+	}
+	sp.pipe = os.NewFile(uintptr(fd), "overseer-pipe")
+}
+
+//send a message to the master process
+func (sp *slave) send(msg string) {
+	if sp.pipe == nil {
+		return
+	}
+	sp.pipeMux.Lock()
+	defer sp.pipeMux.Unlock()
+	if _, err := sp.pipe.Write([]byte(msg + "\n")); err != nil {
+		sp.debugf("failed to message master: %s", err)
+	}
+}
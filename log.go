@@ -0,0 +1,44 @@
+package overseer
+
+import (
+	"fmt"
+	"log"
+)
+
+//Logger receives overseer's logs, as a message followed by
+//alternating attribute keys and values. It is satisfied by
+//*slog.Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+//logger writes to Config.Logger when set, otherwise to
+//the standard logger when enabled by Debug and NoWarn
+type logger struct {
+	config *Config
+	prefix string
+	attrs  []interface{}
+}
+
+//with returns a logger which includes the given attributes
+func (l logger) with(attrs ...interface{}) logger {
+	l.attrs = append(append([]interface{}{}, l.attrs...), attrs...)
+	return l
+}
+
+func (l logger) debugf(f string, args ...interface{}) {
+	if l.config.Logger != nil {
+		l.config.Logger.Debug(fmt.Sprintf(f, args...), l.attrs...)
+	} else if l.config.Debug {
+		log.Printf(l.prefix+f, args...)
+	}
+}
+
+func (l logger) warnf(f string, args ...interface{}) {
+	if l.config.Logger != nil {
+		l.config.Logger.Warn(fmt.Sprintf(f, args...), l.attrs...)
+	} else if l.config.Debug || !l.config.NoWarn {
+		log.Printf(l.prefix+f, args...)
+	}
+}
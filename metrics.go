@@ -0,0 +1,274 @@
+package overseer
+
+//the master process counts what it has done, and collects
+//connection stats from its slaves over the message pipe.
+//these are served in the Prometheus text format on
+//Config.MetricsAddress:
+//
+//  overseer_fetches_total 12
+//  overseer_restart_duration_seconds_sum 4.2
+//  overseer_connections_active{address=":3000",worker="0",slave_id="3"} 17
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type metricDesc struct {
+	name, kind, help string
+}
+
+//metrics with a single value, in the order they're written.
+//summaries are written as _sum and _count.
+var metricDescs = []metricDesc{
+	{"overseer_fetches_total", "counter", "Number of fetches."},
+	{"overseer_fetch_errors_total", "counter", "Number of fetches which failed."},
+	{"overseer_fetch_bytes_total", "counter", "Number of bytes of fetched binaries."},
+	{"overseer_verify_failures_total", "counter", "Number of fetched binaries which failed checksum or signature verification."},
+	{"overseer_sanity_check_failures_total", "counter", "Number of fetched binaries which failed the sanity check."},
+	{"overseer_upgrades_total", "counter", "Number of times the binary was replaced."},
+	{"overseer_rollbacks_total", "counter", "Number of times the previous binary was restored."},
+	{"overseer_restarts_total", "counter", "Number of graceful restarts."},
+	{"overseer_restart_failures_total", "counter", "Number of new programs which didn't become ready."},
+	{"overseer_restart_duration_seconds", "summary", "Duration of graceful restarts."},
+	{"overseer_drain_duration_seconds", "summary", "Duration from asking a program to shut down until it exited."},
+	{"overseer_forced_kills_total", "counter", "Number of programs killed after not exiting in time."},
+	{"overseer_slave_exits_total", "counter", "Number of program exits."},
+}
+
+//metrics are nil unless Config.MetricsAddress is set
+type metrics struct {
+	mut    sync.Mutex
+	values map[string]float64
+	//per slave id, per listener
+	conns map[int][]connStats
+	//per listener, including exited slaves
+	forced []int64
+}
+
+//connection stats of one listener, see Listener
+type connStats struct {
+	active, forced int64
+}
+
+func newMetrics(listeners int) *metrics {
+	return &metrics{
+		values: map[string]float64{},
+		conns:  map[int][]connStats{},
+		forced: make([]int64, listeners),
+	}
+}
+
+func (m *metrics) add(name string, v float64) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.values[name] += v
+	m.mut.Unlock()
+}
+
+func (m *metrics) observe(name string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mut.Lock()
+	m.values[name+"_sum"] += d.Seconds()
+	m.values[name+"_count"]++
+	m.mut.Unlock()
+}
+
+//event updates the counters, see master.emit
+func (m *metrics) event(e Event) {
+	if m == nil {
+		return
+	}
+	switch e.Type {
+	case EventFetchStarted:
+		m.add("overseer_fetches_total", 1)
+	case EventFetchFailed:
+		m.add("overseer_fetch_errors_total", 1)
+	case EventVerifyFailed:
+		m.add("overseer_verify_failures_total", 1)
+	case EventSanityCheckFailed:
+		m.add("overseer_sanity_check_failures_total", 1)
+	case EventBinaryReplaced:
+		m.add("overseer_upgrades_total", 1)
+	case EventRollback:
+		m.add("overseer_rollbacks_total", 1)
+	case EventRestartCompleted:
+		m.add("overseer_restarts_total", 1)
+		m.observe("overseer_restart_duration_seconds", e.Duration)
+	case EventRestartFailed:
+		m.add("overseer_restart_failures_total", 1)
+	case EventForcedKill:
+		m.add("overseer_forced_kills_total", 1)
+	case EventSlaveExited:
+		m.add("overseer_slave_exits_total", 1)
+	}
+}
+
+//slaveExited records the drain duration, and
+//drops the exited slave's connection stats
+func (m *metrics) slaveExited(s *slaveProcess) {
+	if m == nil {
+		return
+	}
+	if at := atomic.LoadInt64(&s.drainAt); at != 0 {
+		m.observe("overseer_drain_duration_seconds", time.Since(time.Unix(0, at)))
+	}
+	m.mut.Lock()
+	delete(m.conns, s.id)
+	m.mut.Unlock()
+}
+
+//connStats handles a stats message from a slave
+func (m *metrics) connStats(s *slaveProcess, args string) error {
+	if m == nil {
+		return nil
+	}
+	fields := strings.Fields(args)
+	stats := make([]connStats, len(fields))
+	for i, f := range fields {
+		if _, err := fmt.Sscanf(f, "%d/%d", &stats[i].active, &stats[i].forced); err != nil {
+			return fmt.Errorf("invalid stats %q", f)
+		}
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	prev := m.conns[s.id]
+	for i, c := range stats {
+		if i >= len(m.forced) {
+			break
+		}
+		//forced closes are reported as a running total
+		m.forced[i] += c.forced
+		if i < len(prev) {
+			m.forced[i] -= prev[i].forced
+		}
+	}
+	m.conns[s.id] = stats
+	return nil
+}
+
+//serveMetrics is run once the sockets are retrieved,
+//and runs until the master process has stopped
+func (mp *master) serveMetrics() error {
+	a, err := parseServiceAddress(mp.MetricsAddress)
+	if err != nil {
+		return fmt.Errorf("Invalid metrics address %s (%s)", mp.MetricsAddress, err)
+	}
+	l, err := a.listen()
+	if err != nil {
+		return err
+	}
+	//removed once stopped
+	if u, ok := l.(*net.UnixListener); ok {
+		u.SetUnlinkOnClose(true)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		mp.writeMetrics(w)
+	})
+	go func() {
+		<-mp.stopped
+		l.Close()
+	}()
+	go http.Serve(l, mux)
+	mp.debugf("serving metrics on %s", mp.MetricsAddress)
+	return nil
+}
+
+func (mp *master) writeMetrics(out io.Writer) {
+	m := mp.metrics
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	m.mut.Lock()
+	for _, d := range metricDescs {
+		header(d.name, d.kind, d.help)
+		if d.kind == "summary" {
+			fmt.Fprintf(w, "%s_sum %s\n", d.name, formatValue(m.values[d.name+"_sum"]))
+			fmt.Fprintf(w, "%s_count %s\n", d.name, formatValue(m.values[d.name+"_count"]))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", d.name, formatValue(m.values[d.name]))
+		}
+	}
+	header("overseer_connections_forced_closed_total", "counter", "Number of connections closed by force after the terminate timeout.")
+	for i, n := range m.forced {
+		fmt.Fprintf(w, "overseer_connections_forced_closed_total{address=%s} %d\n", quoteLabel(mp.Addresses[i]), n)
+	}
+	header("overseer_connections_active", "gauge", "Number of open connections accepted by each program.")
+	ids := make([]int, 0, len(m.conns))
+	for id := range m.conns {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		worker := -1
+		for _, wk := range mp.workers {
+			if s := wk.current(); s != nil && s.id == id {
+				worker = wk.index
+			}
+		}
+		for i, c := range m.conns[id] {
+			if i >= len(mp.Addresses) {
+				break
+			}
+			fmt.Fprintf(w, "overseer_connections_active{address=%s,worker=\"%d\",slave_id=\"%d\"} %d\n",
+				quoteLabel(mp.Addresses[i]), worker, id, c.active)
+		}
+	}
+	m.mut.Unlock()
+	header("overseer_slave_uptime_seconds", "gauge", "Seconds since each worker's program started.")
+	for _, wk := range mp.workers {
+		if s := wk.current(); s != nil {
+			fmt.Fprintf(w, "overseer_slave_uptime_seconds{worker=\"%d\",slave_id=\"%d\"} %s\n",
+				wk.index, s.id, formatValue(time.Since(s.startedAt).Seconds()))
+		}
+	}
+	mp.binMux.Lock()
+	hash := fmt.Sprintf("%x", mp.binHash)
+	mp.binMux.Unlock()
+	header("overseer_binary_info", "gauge", "The hash of the current binary, see State.ID.")
+	fmt.Fprintf(w, "overseer_binary_info{hash=\"%s\"} 1\n", hash)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+//sendStats reports the connection stats of each listener to the master
+func (sp *slave) sendStats() {
+	stats := make([]string, len(sp.listeners))
+	for i, l := range sp.listeners {
+		stats[i] = fmt.Sprintf("%d/%d", atomic.LoadInt64(&l.active), atomic.LoadInt64(&l.forced))
+	}
+	sp.send(msgStats + " " + strings.Join(stats, " "))
+}
+
+//reportStats is run in a goroutine when metrics are enabled
+func (sp *slave) reportStats(d time.Duration) {
+	for {
+		sp.sendStats()
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,117 @@
+package overseer
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	mp := &master{Config: &Config{Addresses: []string{":3000", `unix:///run/a"b.sock`}}}
+	mp.metrics = newMetrics(len(mp.Addresses))
+	mp.binHash = []byte{0xab, 0xcd}
+	w := &worker{index: 1}
+	w.setCurrent(&slaveProcess{id: 3, startedAt: time.Now()})
+	mp.workers = []*worker{{index: 0}, w}
+	for _, e := range []Event{
+		{Type: EventFetchStarted},
+		{Type: EventFetchStarted},
+		{Type: EventFetchFailed},
+		{Type: EventRestartCompleted, Duration: 1500 * time.Millisecond},
+		{Type: EventRestartCompleted, Duration: 500 * time.Millisecond},
+		{Type: EventForcedKill},
+	} {
+		mp.metrics.event(e)
+	}
+	//forced closes are running totals of each slave
+	exited := &slaveProcess{id: 2}
+	for _, s := range []struct {
+		slave *slaveProcess
+		args  string
+	}{
+		{exited, "1/1 0/0"},
+		{exited, "0/2 0/1"},
+		{w.current(), "17/0 4/0"},
+		{w.current(), "12/1 4/0"},
+	} {
+		if err := mp.metrics.connStats(s.slave, s.args); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mp.metrics.slaveExited(exited)
+	if err := mp.metrics.connStats(exited, "1/x"); err == nil {
+		t.Fatal("expected invalid stats to fail")
+	}
+	out := &bytes.Buffer{}
+	mp.writeMetrics(out)
+	for _, line := range []string{
+		"# TYPE overseer_fetches_total counter",
+		"overseer_fetches_total 2",
+		"overseer_fetch_errors_total 1",
+		"overseer_upgrades_total 0",
+		"overseer_restarts_total 2",
+		"# TYPE overseer_restart_duration_seconds summary",
+		"overseer_restart_duration_seconds_sum 2",
+		"overseer_restart_duration_seconds_count 2",
+		"overseer_forced_kills_total 1",
+		`overseer_connections_forced_closed_total{address=":3000"} 3`,
+		`overseer_connections_forced_closed_total{address="unix:///run/a\"b.sock"} 1`,
+		`overseer_connections_active{address=":3000",worker="1",slave_id="3"} 12`,
+		`overseer_connections_active{address="unix:///run/a\"b.sock",worker="1",slave_id="3"} 4`,
+		`overseer_binary_info{hash="abcd"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out.String(), `slave_id="2"`) {
+		t.Errorf("exited slave in:\n%s", out)
+	}
+	if !strings.Contains(out.String(), `overseer_slave_uptime_seconds{worker="1",slave_id="3"} `) {
+		t.Errorf("missing uptime in:\n%s", out)
+	}
+}
+
+func TestServeMetricsUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overseer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.sock")
+	mp := &master{Config: &Config{MetricsAddress: "unix://" + path}, stopped: make(chan bool)}
+	mp.metrics = newMetrics(0)
+	if err := mp.serveMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://overseer/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), "overseer_fetches_total 0\n") {
+		t.Fatalf("unexpected metrics:\n%s", b)
+	}
+	close(mp.stopped)
+	for i := 0; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		} else if i == 50 {
+			t.Fatal("socket not removed once stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
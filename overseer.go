@@ -3,6 +3,9 @@
 package overseer
 
 import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"log"
@@ -17,7 +20,15 @@ const (
 	envSlaveID        = "OVERSEER_SLAVE_ID"
 	envIsSlave        = "OVERSEER_IS_SLAVE"
 	envNumFDs         = "OVERSEER_NUM_FDS"
+	envNumPacketFDs   = "OVERSEER_NUM_PACKET_FDS"
+	envFDNames        = "OVERSEER_FD_NAMES"
+	envPipeFD         = "OVERSEER_PIPE_FD"
+	envHeartbeat      = "OVERSEER_HEARTBEAT"
+	envStatsInterval  = "OVERSEER_STATS_INTERVAL"
+	envWorkerIndex    = "OVERSEER_WORKER_INDEX"
+	envWorkerCount    = "OVERSEER_WORKER_COUNT"
 	envBinID          = "OVERSEER_BIN_ID"
+	envBinLegacyID    = "OVERSEER_BIN_LEGACY_ID"
 	envBinPath        = "OVERSEER_BIN_PATH"
 	envBinCheck       = "OVERSEER_BIN_CHECK"
 	envBinCheckLegacy = "GO_UPGRADE_BIN_CHECK"
@@ -30,12 +41,72 @@ type Config struct {
 	Required bool
 	//Program's main function
 	Program func(state State)
+	//Command runs an external program instead of Program, such as a
+	//binary which doesn't use overseer or isn't written in Go. The
+	//sockets are passed to it with systemd socket activation (see
+	//sd_listen_fds(3)), Addresses then PacketAddresses, starting at
+	//file descriptor 3. Fetched binaries replace Command[0], and as
+	//they can't be sanity checked, they must be verified with PublicKeys
+	//or a checksum manifest (see fetcher.ChecksumFetcher).
+	//Since the program can't release its sockets, restarts start the
+	//new program first (see WaitForReady), then send the old program
+	//the DrainSignal. The program can't call State.Ready, so it's ready
+	//once ReadyProbe succeeds for its pid, or as soon as it's started
+	//without a ReadyProbe. Requires a posix OS.
+	Command []string
+	//DrainSignal is sent to the Command to gracefully stop it.
+	//Defaults to SIGTERM.
+	DrainSignal os.Signal
 	//Program's zero-downtime socket listening address (set this or Addresses)
 	Address string
-	//Program's zero-downtime socket listening addresses (set this or Address)
+	//Program's zero-downtime socket listening addresses (set this or Address).
+	//Plain "host:port" addresses are TCP. Unix sockets are specified with
+	//"unix:///path/to/app.sock", optionally followed by "?mode=0660",
+	//"&owner=user" and "&group=group" to set the socket file permissions.
+	//Stale socket files left behind by a previous run are removed.
+	//The keep-alive period of accepted TCP connections defaults to
+	//3 minutes, and is set with "tcp://:3000?keepalive=30s", or
+	//disabled with "?keepalive=off" (also on systemd:// addresses).
+	//Behind a load balancer, "?proxy=on" parses PROXY protocol v1
+	//and v2 headers, so RemoteAddr is the original client's. These
+	//are only parsed from the sources in the required option
+	//"&proxy_trusted=10.0.0.0/8,192.0.2.1", and must arrive within
+	//"&proxy_timeout=5s" (see ProxyProtocol).
+	//When started by a systemd .socket unit, sockets passed in
+	//with LISTEN_FDS are used instead of binding new ones. These
+	//are matched by address, or by name with "systemd://name"
+	//(see FileDescriptorName= in systemd.socket(5)).
 	Addresses []string
+	//TLS terminates TLS on the State.Listeners of these addresses,
+	//keyed by their entry in Addresses. Certificates are reloaded
+	//when their files change, without restarting the program.
+	TLS map[string]TLSConfig
+	//TLSReloadSignal also reloads the TLS certificates, such as
+	//SIGHUP. Defaults to nil, file changes only.
+	TLSReloadSignal os.Signal
+	//Program's zero-downtime packet addresses, such as "udp://:53" or
+	//"unixgram:///run/app.sock" (plain "host:port" addresses are UDP),
+	//or "systemd://name" to use a socket activated packet socket.
+	//These are bound once by the master process and passed to
+	//each new program in State.PacketConns. During a graceful
+	//restart, the old program stops reading from its packet
+	//conns before the new program is started, except with
+	//WaitForReady (and so with Command), where the new program
+	//starts first, and both read until the old program is
+	//asked to shut down (commands until they exit).
+	PacketAddresses []string
+	//Workers is the number of programs run in parallel, each
+	//accepting connections on the same sockets. During a restart,
+	//workers are restarted one at a time, so the others keep
+	//serving. Workers other than 1 require a posix OS.
+	//Defaults to 1.
+	Workers int
 	//RestartSignal will manually trigger a graceful restart. Defaults to SIGUSR2.
 	RestartSignal os.Signal
+	//ShutdownSignal will trigger a graceful shutdown of the program
+	//(see Shutdown), instead of being passed through to the program.
+	//Defaults to nil, no shutdown signal.
+	ShutdownSignal os.Signal
 	//TerminateTimeout controls how long overseer should
 	//wait for the program to terminate itself. After this
 	//timeout, overseer will issue a SIGKILL.
@@ -44,13 +115,114 @@ type Config struct {
 	//This helps to prevent unwieldy fetch.Interfaces from hogging
 	//too many resources. Defaults to 1 second.
 	MinFetchInterval time.Duration
+	//WaitForReady enables readiness-gated restarts. Instead of asking
+	//the running program to shut down first, the new program is
+	//started alongside it, and the old program is only asked to shut
+	//down once the new program has called State.Ready() (or ReadyProbe
+	//succeeds). If neither happens within ReadyTimeout, the new
+	//program is killed and the old program keeps serving. Requires
+	//a posix OS, since the new program reports back over a pipe.
+	WaitForReady bool
+	//ReadyTimeout is how long WaitForReady waits for the new program.
+	//Defaults to 30 seconds.
+	ReadyTimeout time.Duration
+	//ReadyProbe, when set with WaitForReady, is polled by the master
+	//process during a restart, with the pid of the new program. The
+	//new program is considered ready as soon as ReadyProbe returns nil.
+	//All programs share the listening sockets, so the probe must check
+	//something only the new program provides (such as a health port
+	//or file derived from its pid), not the service's address.
+	ReadyProbe func(pid int) error
+	//Supervise restarts the program when it crashes (exits with a
+	//non-zero code) instead of exiting the master process with the
+	//same code. The listening sockets remain open while the program
+	//is restarted, so clients only see queued connections. Programs
+	//stopped by SIGTERM or an interrupt sent to the master process
+	//aren't restarted, the master process exits with their code.
+	Supervise bool
+	//CrashBackoff is the delay before restarting a crashed program,
+	//which doubles (with jitter) after each consecutive crash up to
+	//CrashBackoffMax. Defaults to 1 second.
+	CrashBackoff time.Duration
+	//CrashBackoffMax is the maximum restart delay. A program which
+	//runs for longer than this resets the backoff. Defaults to 1 minute.
+	CrashBackoffMax time.Duration
+	//CrashLimit is the number of consecutive crashes after which
+	//overseer gives up and exits with the program's exit code.
+	//Defaults to 0, unlimited.
+	CrashLimit int
+	//RollbackWindow enables automatic rollbacks after upgrades. The
+	//previous binary is kept alongside the current binary (with a
+	//"-previous" suffix) and if the upgraded program exits with a
+	//non-zero code within RollbackWindow of starting, the previous
+	//binary is restored and restarted. The hash of the bad binary is
+	//remembered, so it isn't installed again by this process.
+	RollbackWindow time.Duration
+	//PublicKeys enables signature verification of fetched binaries.
+	//The Fetcher must implement fetcher.SignatureFetcher, and binaries
+	//without a valid signature from one of these keys are discarded.
+	//Signatures are of the file as published (such as app.gz), and
+	//can be created with Sign or the overseer-sign tool.
+	PublicKeys []ed25519.PublicKey
+	//HashAlgorithm is used to identify binaries (see State.ID), and
+	//to detect fetched binaries which are already running. Its
+	//package must be imported. Defaults to crypto.SHA256.
+	HashAlgorithm crypto.Hash
 	//PreUpgrade runs after a binary has been retrieved, user defined checks
 	//can be run here and returning an error will cancel the upgrade.
 	PreUpgrade func(tempBinaryPath string) error
+	//OnEvent is called by the master process as things happen, such
+	//as fetches, upgrades, restarts and program exits (see Event).
+	//It is called synchronously, so it should not block.
+	OnEvent func(Event)
+	//MetricsAddress enables metrics, served by the master process in
+	//the Prometheus text format at "/metrics" on this address, such as
+	//"localhost:9100" or "unix:///run/app-metrics.sock". These include
+	//fetches, upgrades, restarts, drain durations and forced kills, and
+	//on posix, the connections of each program and how many of those
+	//were closed by force after TerminateTimeout. Only tcp:// and unix://
+	//addresses are supported, without options other than those of unix
+	//sockets. Defaults to "", disabled.
+	MetricsAddress string
+	//ControlAddress enables the control socket, which is served by
+	//the master process on this address, such as "unix:///run/app.ctl"
+	//(use "?mode=0600" to restrict access). Tools can then query the
+	//Status, restart, fetch, pause and resume fetching, or shut down
+	//without sending signals (see ControlRequest). Only unix sockets
+	//are supported, since there is no authentication, anyone who can
+	//connect can shut down the service. Defaults to "", disabled.
+	ControlAddress string
 	//Debug enables all [overseer] logs.
 	Debug bool
 	//NoWarn disables warning [overseer] logs.
 	NoWarn bool
+	//Logger receives all [overseer] logs with structured attributes
+	//(such as slave_id, bin_hash and exit_code) instead of the standard
+	//logger. Debug and NoWarn don't apply, use the Logger's level instead.
+	//A *slog.Logger can be used directly. The Logger is also passed
+	//to fetchers which implement fetcher.LoggerSetter.
+	Logger Logger
+	//ReexecMaster upgrades the master process too. Once the programs
+	//have been restarted after an upgrade, the master process execs
+	//the new binary in place (keeping its PID), which takes over the
+	//sockets and the running programs, so changes to overseer itself
+	//take effect without a service restart. Requires a posix OS.
+	ReexecMaster bool
+	//HandoffLimit is the maximum size of State.Handoff. Larger writes
+	//fail with ErrHandoffTooLarge. Defaults to 64MB.
+	HandoffLimit int64
+	//HandoffTimeout is how long the next program waits for the
+	//previous program to close its State.Handoff, from when the
+	//previous program was asked to shut down. State.Inherited is
+	//empty after the timeout. The next program then has as long
+	//again to read it, or it's cut short. Defaults to 10 seconds.
+	HandoffTimeout time.Duration
+	//HandoffConns enables State.HandoffConn, so programs can pass
+	//long-lived connections (such as websockets) to the next program
+	//of their worker, instead of closing them during a restart. The
+	//connections are passed over a unix socket, and received from
+	//State.ResumedConns. Requires a posix OS.
+	HandoffConns bool
 	//NoRestart disables all restarts, this option essentially converts
 	//the RestartSignal into a "ShutdownSignal".
 	NoRestart bool
@@ -63,9 +235,31 @@ type Config struct {
 
 func validate(c *Config) error {
 	//validate
-	if c.Program == nil {
+	if c.Program == nil && len(c.Command) == 0 {
 		return errors.New("overseer.Config.Program required")
 	}
+	if len(c.Command) > 0 {
+		if !pipesSupported {
+			return errors.New("overseer.Config.Command not supported on this os")
+		}
+		if c.DrainSignal == nil {
+			c.DrainSignal = SIGTERM
+		}
+		c.WaitForReady = true
+	}
+	if c.ReexecMaster {
+		if !pipesSupported {
+			return errors.New("overseer.Config.ReexecMaster not supported on this os")
+		} else if len(c.Command) > 0 {
+			return errors.New("overseer.Config.ReexecMaster and Command cant both be set")
+		}
+	}
+	if c.HandoffConns && !pipesSupported {
+		return errors.New("overseer.Config.HandoffConns not supported on this os")
+	}
+	if c.WaitForReady && !pipesSupported {
+		return errors.New("overseer.Config.WaitForReady not supported on this os")
+	}
 	if c.Address != "" {
 		if len(c.Addresses) > 0 {
 			return errors.New("overseer.Config.Address and Addresses cant both be set")
@@ -74,12 +268,56 @@ func validate(c *Config) error {
 	} else if len(c.Addresses) > 0 {
 		c.Address = c.Addresses[0]
 	}
+	//external programs accept their own connections
+	for _, addr := range c.Addresses {
+		if a, err := parseAddress(addr); err == nil && a.proxy != nil && len(c.Command) > 0 {
+			return errors.New("overseer.Config.Command cant parse the PROXY protocol")
+		}
+	}
+	if c.Workers <= 0 {
+		c.Workers = 1
+	} else if c.Workers > 1 && !pipesSupported {
+		return errors.New("overseer.Config.Workers not supported on this os")
+	}
 	if c.RestartSignal == nil {
 		c.RestartSignal = SIGUSR2
 	}
 	if c.TerminateTimeout <= 0 {
 		c.TerminateTimeout = 30 * time.Second
 	}
+	if c.ReadyTimeout <= 0 {
+		c.ReadyTimeout = 30 * time.Second
+	}
+	if c.CrashBackoff <= 0 {
+		c.CrashBackoff = 1 * time.Second
+	}
+	if c.CrashBackoffMax <= 0 {
+		c.CrashBackoffMax = 1 * time.Minute
+	}
+	if c.HashAlgorithm == 0 {
+		c.HashAlgorithm = crypto.SHA256
+	} else if !c.HashAlgorithm.Available() {
+		return errors.New("overseer.Config.HashAlgorithm not available (import its package)")
+	}
+	if c.HandoffLimit <= 0 {
+		c.HandoffLimit = 64 << 20
+	}
+	if c.HandoffTimeout <= 0 {
+		c.HandoffTimeout = 10 * time.Second
+	}
+	if err := validateTLS(c); err != nil {
+		return err
+	}
+	if c.MetricsAddress != "" {
+		if _, err := parseServiceAddress(c.MetricsAddress); err != nil {
+			return fmt.Errorf("overseer.Config.MetricsAddress %s is invalid (%s)", c.MetricsAddress, err)
+		}
+	}
+	if c.ControlAddress != "" {
+		if _, err := parseControlAddress(c.ControlAddress); err != nil {
+			return fmt.Errorf("overseer.Config.ControlAddress %s is invalid (%s)", c.ControlAddress, err)
+		}
+	}
 	if c.MinFetchInterval <= 0 {
 		c.MinFetchInterval = 1 * time.Second
 	}
@@ -98,11 +336,14 @@ func RunErr(c Config) error {
 func Run(c Config) {
 	err := runErr(&c)
 	if err != nil {
-		if c.Required {
+		if c.Required || c.Program == nil {
+			if c.Logger != nil {
+				c.Logger.Warn(err.Error())
+				os.Exit(1)
+			}
 			log.Fatalf("[overseer] %s", err)
-		} else if c.Debug || !c.NoWarn {
-			log.Printf("[overseer] disabled. run failed: %s", err)
 		}
+		logger{config: &c, prefix: "[overseer] "}.warnf("disabled. run failed: %s", err)
 		c.Program(DisabledState)
 		return
 	}
@@ -139,6 +380,7 @@ func SanityCheck() {
 //abstraction over master/slave
 var currentProcess interface {
 	triggerRestart()
+	shutdown(ctx context.Context) error
 	run() error
 }
 
@@ -170,6 +412,19 @@ func Restart() {
 	}
 }
 
+//Shutdown programmatically triggers a graceful shutdown. The fetcher is
+//stopped, and the program's GracefulShutdown channel is closed. Once the
+//program has exited, RunErr returns nil (and Run exits). If the program
+//is still running when ctx is done, it is killed.
+//When called by the program, Shutdown returns once GracefulShutdown is
+//closed, or when ctx is done.
+func Shutdown(ctx context.Context) error {
+	if currentProcess == nil {
+		return errors.New("overseer not running")
+	}
+	return currentProcess.shutdown(ctx)
+}
+
 //IsSupported returns whether overseer is supported on the current OS.
 func IsSupported() bool {
 	return supported
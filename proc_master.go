@@ -2,75 +2,171 @@ package overseer
 
 import (
 	"bytes"
+	"context"
+	"crypto"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"net"
+	mathrand "math/rand"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/jpillora/overseer/fetcher"
 )
 
 var tmpBinPath = filepath.Join(os.TempDir(), "overseer-"+token()+extension())
 
+//returned by workers after a graceful shutdown
+var errStopped = errors.New("stopped")
+
 //a overseer master process
 type master struct {
 	*Config
 	slaveID             int
-	slaveCmd            *exec.Cmd
+	workers             []*worker
 	slaveExtraFiles     []*os.File
+	slaveFDNames        []string
 	binPath, tmpBinPath string
 	binPerms            os.FileMode
+	binMux              sync.Mutex
 	binHash             []byte
+	binLegacyHash       []byte
+	prevBinPath         string
+	prevBinHash         []byte
+	prevBinLegacyHash   []byte
+	probationUntil      time.Time
+	badHashes           map[string]bool
+	rollbacks           int
+	exitMux             sync.Mutex
+	exiting             bool
+	exitCode            int
+	shuttingDown        bool
+	stopped             chan bool
+	signals             chan os.Signal
 	restartMux          sync.Mutex
 	restarting          bool
 	restartedAt         time.Time
-	restarted           chan bool
-	awaitingUSR1        bool
-	descriptorsReleased chan bool
 	signalledAt         time.Time
 	printCheckUpdate    bool
+	fetcher             fetcher.ContextInterface
+	fetchCtx            context.Context
+	stopFetch           context.CancelFunc
+	notifier            *notifier
+	aliveAt             int64
+	metrics             *metrics
+	startedAt           time.Time
+	fetchNow            chan bool
+	controlMux          sync.Mutex
+	fetchPaused         bool
+	lastFetch           *FetchStatus
+	subscribers         map[chan Event]bool
+	slavesMux           sync.Mutex
+	slaves              map[*slaveProcess]bool
+	handoffs            sync.WaitGroup
+	connsDir            string
 }
 
 func (mp *master) run() error {
 	mp.debugf("run")
+	mp.startedAt = time.Now()
 	if err := mp.checkBinary(); err != nil {
 		return err
 	}
+	if err := mp.checkTLS(); err != nil {
+		return err
+	}
+	//cancelled once shutting down
+	mp.fetchCtx, mp.stopFetch = context.WithCancel(context.Background())
+	//woken early by the control socket
+	mp.fetchNow = make(chan bool)
+	mp.fetchCtx = fetcher.WithFetchNow(mp.fetchCtx, mp.fetchNow)
+	mp.notifier = newNotifier()
+	if mp.notifier != nil && mp.notifier.watchdog > 0 {
+		go mp.watchdogLoop()
+	}
 	if mp.Config.Fetcher != nil {
+		if ls, ok := mp.Config.Fetcher.(fetcher.LoggerSetter); ok && mp.Config.Logger != nil {
+			ls.SetLogger(mp.Config.Logger)
+		}
 		if err := mp.Config.Fetcher.Init(); err != nil {
 			mp.warnf("fetcher init failed (%s). fetcher disabled.", err)
 			mp.Config.Fetcher = nil
 		}
 	}
+	if mp.Config.Fetcher != nil && len(mp.Config.PublicKeys) > 0 {
+		if _, ok := mp.Config.Fetcher.(fetcher.SignatureFetcher); !ok {
+			return errors.New("overseer.Config.PublicKeys requires a fetcher which supports signatures")
+		}
+	}
+	//commands can't be sanity checked, so fetched binaries must be verified
+	if mp.Config.Fetcher != nil && len(mp.Command) > 0 && len(mp.Config.PublicKeys) == 0 {
+		if _, ok := mp.Config.Fetcher.(fetcher.ChecksumFetcher); !ok {
+			return errors.New("overseer.Config.Command requires PublicKeys or a fetcher which supports checksums")
+		}
+	}
 	mp.setupSignalling()
-	if err := mp.retreiveFileDescriptors(); err != nil {
+	//after a re-exec, the sockets and slaves are adopted
+	state := takeMasterState()
+	adopted := state != nil && mp.adopt(state)
+	if !adopted {
+		if err := mp.retreiveFileDescriptors(); err != nil {
+			return err
+		}
+	}
+	if err := mp.initConnsDir(); err != nil {
 		return err
 	}
+	if mp.MetricsAddress != "" {
+		mp.metrics = newMetrics(len(mp.Addresses))
+		if err := mp.serveMetrics(); err != nil {
+			return err
+		}
+	}
+	if mp.ControlAddress != "" {
+		if err := mp.serveControl(); err != nil {
+			return err
+		}
+	}
 	if mp.Config.Fetcher != nil {
+		mp.fetcher = fetcher.WithContext(mp.Config.Fetcher)
 		mp.printCheckUpdate = true
 		mp.fetch()
 		go mp.fetchLoop()
 	}
-	return mp.forkLoop()
+	//adopted slaves are using the previous sockets
+	if state != nil && !adopted {
+		go mp.triggerRestart()
+	}
+	err := mp.forkLoop()
+	mp.removeConnsDir()
+	return err
 }
 
 func (mp *master) checkBinary() error {
 	//get path to binary and confirm its writable
 	binPath, err := os.Executable()
+	if len(mp.Command) > 0 {
+		binPath, err = commandPath(mp.Command[0])
+	}
 	if err != nil {
 		return fmt.Errorf("failed to find binary path (%s)", err)
 	}
 	mp.binPath = binPath
+	mp.prevBinPath = strings.TrimSuffix(binPath, extension()) + "-previous" + extension()
+	mp.badHashes = map[string]bool{}
 	if info, err := os.Stat(binPath); err != nil {
 		return fmt.Errorf("failed to stat binary (%s)", err)
 	} else if info.Size() == 0 {
@@ -84,9 +180,11 @@ func (mp *master) checkBinary() error {
 		return fmt.Errorf("cannot read binary (%s)", err)
 	}
 	//initial hash of file
-	hash := sha1.New()
-	io.Copy(hash, f)
+	hash := mp.HashAlgorithm.New()
+	legacy := sha1.New()
+	io.Copy(io.MultiWriter(hash, legacy), f)
 	mp.binHash = hash.Sum(nil)
+	mp.binLegacyHash = legacy.Sum(nil)
 	f.Close()
 	//test bin<->tmpbin moves
 	if mp.Config.Fetcher != nil {
@@ -102,13 +200,21 @@ func (mp *master) checkBinary() error {
 
 func (mp *master) setupSignalling() {
 	//updater-forker comms
-	mp.restarted = make(chan bool)
-	mp.descriptorsReleased = make(chan bool)
+	mp.workers = make([]*worker, mp.Config.Workers)
+	for i := range mp.workers {
+		mp.workers[i] = &worker{
+			index:               i,
+			restarted:           make(chan bool, 1),
+			cutoverDone:         make(chan *slaveProcess),
+			descriptorsReleased: make(chan bool, 1),
+		}
+	}
+	mp.stopped = make(chan bool)
 	//read all master process signals
-	signals := make(chan os.Signal)
-	signal.Notify(signals)
+	mp.signals = make(chan os.Signal, 1)
+	signal.Notify(mp.signals)
 	go func() {
-		for s := range signals {
+		for s := range mp.signals {
 			mp.handleSignal(s)
 		}
 	}()
@@ -118,53 +224,88 @@ func (mp *master) handleSignal(s os.Signal) {
 	if s == mp.RestartSignal {
 		//user initiated manual restart
 		go mp.triggerRestart()
+	} else if mp.ShutdownSignal != nil && s == mp.ShutdownSignal {
+		//user initiated graceful shutdown
+		go mp.shutdownTimeout(mp.TerminateTimeout)
 	} else if s.String() == "child exited" {
 		// will occur on every restart, ignore it
 	} else
 	//**during a restart** a SIGUSR1 signals
 	//to the master process that, the file
-	//descriptors have been released
-	if mp.awaitingUSR1 && s == SIGUSR1 {
+	//descriptors have been released. slaves
+	//without a message pipe (single worker only)
+	if s == SIGUSR1 && mp.workers[0].release(nil) {
 		mp.debugf("signaled, sockets ready")
-		mp.awaitingUSR1 = false
-		mp.descriptorsReleased <- true
+	} else
+	//old slaves dont hand over their descriptors
+	//in a cutover, and new slaves shouldnt get it
+	if mp.WaitForReady && s == SIGUSR1 {
+		mp.logger().with("signal", s.String()).debugf("signal discarded (%s)", s)
 	} else
 	//while the slave process is running, proxy
 	//all signals through
-	if mp.slaveCmd != nil && mp.slaveCmd.Process != nil {
-		mp.debugf("proxy signal (%s)", s)
+	if mp.running() {
+		mp.logger().with("signal", s.String()).debugf("proxy signal (%s)", s)
+		if s == SIGTERM || s == os.Interrupt {
+			mp.notify("STOPPING=1")
+			mp.stopFetch()
+			//the programs exit with this signal, which
+			//isn't a crash, so they aren't restarted
+			mp.exitMux.Lock()
+			mp.exiting = true
+			mp.exitMux.Unlock()
+		}
 		mp.sendSignal(s)
 	} else
 	//otherwise if not running, kill on CTRL+c
-	if s == os.Interrupt {
+	//(or when terminated while supervising)
+	if s == os.Interrupt || (mp.Supervise && s == SIGTERM) {
 		mp.debugf("interupt with no slave")
 		os.Exit(1)
 	} else {
-		mp.debugf("signal discarded (%s), no slave process", s)
+		mp.logger().with("signal", s.String()).debugf("signal discarded (%s), no slave process", s)
 	}
 }
 
 func (mp *master) sendSignal(s os.Signal) {
-	if mp.slaveCmd != nil && mp.slaveCmd.Process != nil {
-		if err := mp.slaveCmd.Process.Signal(s); err != nil {
-			mp.debugf("signal failed (%s), assuming slave process died unexpectedly", err)
+	for _, w := range mp.workers {
+		sp := w.current()
+		if sp == nil {
+			continue
+		}
+		if s == mp.drainSignal() {
+			sp.draining()
+		}
+		if err := sp.cmd.Process.Signal(s); err != nil {
+			mp.slaveLog(sp).with("signal", s.String()).debugf("signal failed (%s), assuming slave process died unexpectedly", err)
 			os.Exit(1)
 		}
 	}
 }
 
 func (mp *master) retreiveFileDescriptors() error {
-	mp.slaveExtraFiles = make([]*os.File, len(mp.Config.Addresses))
+	//adopt systemd sockets where possible
+	activated := activatedFiles()
+	mp.slaveExtraFiles = make([]*os.File, len(mp.Config.Addresses), len(mp.Config.Addresses)+len(mp.Config.PacketAddresses))
+	mp.slaveFDNames = make([]string, len(mp.Config.Addresses))
 	for i, addr := range mp.Config.Addresses {
-		a, err := net.ResolveTCPAddr("tcp", addr)
+		a, err := parseAddress(addr)
 		if err != nil {
 			return fmt.Errorf("Invalid address %s (%s)", addr, err)
 		}
-		l, err := net.ListenTCP("tcp", a)
+		if af := a.adopt(activated, false); af != nil {
+			mp.debugf("adopted systemd socket %s for %s", af.name, addr)
+			mp.slaveExtraFiles[i] = af.file
+			mp.slaveFDNames[i] = af.name
+			continue
+		} else if a.network == "systemd" {
+			return fmt.Errorf("No systemd socket named %s", a.addr)
+		}
+		l, err := a.listen()
 		if err != nil {
 			return err
 		}
-		f, err := l.File()
+		f, err := l.(filer).File()
 		if err != nil {
 			return fmt.Errorf("Failed to retreive fd for: %s (%s)", addr, err)
 		}
@@ -173,101 +314,299 @@ func (mp *master) retreiveFileDescriptors() error {
 		}
 		mp.slaveExtraFiles[i] = f
 	}
+	//packet conns are passed after the listeners
+	for _, addr := range mp.Config.PacketAddresses {
+		a, err := parsePacketAddress(addr)
+		if err != nil {
+			return fmt.Errorf("Invalid packet address %s (%s)", addr, err)
+		}
+		if af := a.adopt(activated, true); af != nil {
+			mp.debugf("adopted systemd socket %s for %s", af.name, addr)
+			mp.slaveExtraFiles = append(mp.slaveExtraFiles, af.file)
+			continue
+		} else if a.network == "systemd" {
+			return fmt.Errorf("No systemd socket named %s", a.addr)
+		}
+		c, err := a.listenPacket()
+		if err != nil {
+			return err
+		}
+		f, err := c.(filer).File()
+		if err != nil {
+			return fmt.Errorf("Failed to retreive fd for: %s (%s)", addr, err)
+		}
+		if err := c.Close(); err != nil {
+			return fmt.Errorf("Failed to close packet conn for: %s (%s)", addr, err)
+		}
+		mp.slaveExtraFiles = append(mp.slaveExtraFiles, f)
+	}
+	for _, af := range activated {
+		if !af.used {
+			mp.warnf("systemd socket %s does not match any address, closing", af.name)
+			af.file.Close()
+		}
+	}
 	return nil
 }
 
 //fetchLoop is run in a goroutine
 func (mp *master) fetchLoop() {
 	min := mp.Config.MinFetchInterval
-	time.Sleep(min)
+	delay := min
 	for {
+		select {
+		case <-time.After(delay):
+		case <-mp.fetchNow:
+		case <-mp.fetchCtx.Done():
+			mp.debugf("fetching stopped")
+			return
+		}
 		t0 := time.Now()
 		mp.fetch()
 		//duration fetch of fetch
 		diff := time.Now().Sub(t0)
+		//ensures at least MinFetchInterval delay.
+		//should be throttled by the fetcher!
+		delay = 0
 		if diff < min {
-			delay := min - diff
-			//ensures at least MinFetchInterval delay.
-			//should be throttled by the fetcher!
-			time.Sleep(delay)
+			delay = min - diff
 		}
 	}
 }
 
 func (mp *master) fetch() {
-	if mp.restarting {
-		return //skip if restarting
+	if mp.isRestarting() || mp.fetchCtx.Err() != nil || mp.isFetchPaused() {
+		return //skip if restarting, shutting down or paused
+	}
+	l := mp.logger().with("fetcher", fmt.Sprintf("%T", mp.Config.Fetcher))
+	//the included fetchers wait for their Interval first,
+	//others are timed from when they're called
+	t0 := time.Now()
+	var once sync.Once
+	started := func(at time.Time) {
+		once.Do(func() {
+			t0 = at
+			if mp.printCheckUpdate {
+				l.debugf("checking for updates...")
+			}
+			mp.emit(Event{Type: EventFetchStarted})
+		})
 	}
-	if mp.printCheckUpdate {
-		mp.debugf("checking for updates...")
+	ctx := fetcher.WithStarted(mp.fetchCtx, func() { started(time.Now()) })
+	reader, err := mp.fetcher.FetchContext(ctx)
+	if err != nil && mp.fetchCtx.Err() != nil {
+		l.debugf("fetch cancelled")
+		return
 	}
-	reader, err := mp.Fetcher.Fetch()
+	started(t0)
 	if err != nil {
-		mp.debugf("failed to get latest version: %s", err)
+		l.debugf("failed to get latest version: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Duration: time.Since(t0), Err: err})
 		return
 	}
 	if reader == nil {
 		if mp.printCheckUpdate {
-			mp.debugf("no updates")
+			l.debugf("no updates")
 		}
 		mp.printCheckUpdate = false
+		mp.emit(Event{Type: EventFetchNoUpdate, Duration: time.Since(t0)})
 		return //fetcher has explicitly said there are no updates
 	}
 	mp.printCheckUpdate = true
-	mp.debugf("streaming update...")
 	//optional closer
 	if closer, ok := reader.(io.Closer); ok {
 		defer closer.Close()
 	}
+	download := reader
+	//paused while the fetcher was waiting
+	if mp.isFetchPaused() {
+		l.debugf("fetching paused, update discarded")
+		return
+	}
+	l.debugf("streaming update...")
 	tmpBin, err := os.OpenFile(tmpBinPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
-		mp.warnf("failed to open temp binary: %s", err)
+		l.warnf("failed to open temp binary: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Duration: time.Since(t0), Err: err})
 		return
 	}
 	defer func() {
 		tmpBin.Close()
 		os.Remove(tmpBinPath)
 	}()
-	//tee off to sha1
-	hash := sha1.New()
+	//tee off to the binary hash
+	hash := mp.HashAlgorithm.New()
 	reader = io.TeeReader(reader, hash)
+	//and to sha1, for the legacy id
+	legacy := sha1.New()
+	reader = io.TeeReader(reader, legacy)
+	//and to sha256, for checksums and signatures
+	digest := hash
+	if mp.HashAlgorithm != crypto.SHA256 {
+		digest = sha256.New()
+		reader = io.TeeReader(reader, digest)
+	}
 	//write to a temp file
-	_, err = io.Copy(tmpBin, reader)
+	n, err := io.Copy(tmpBin, reader)
+	mp.metrics.add("overseer_fetch_bytes_total", float64(n))
 	if err != nil {
-		mp.warnf("failed to write temp binary: %s", err)
+		if mp.fetchCtx.Err() != nil {
+			l.debugf("fetch cancelled")
+			return
+		}
+		l.warnf("failed to write temp binary: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Duration: time.Since(t0), Err: err})
 		return
 	}
 	//compare hash
 	newHash := hash.Sum(nil)
-	if bytes.Equal(mp.binHash, newHash) {
-		mp.debugf("hash match - skip")
+	mp.binMux.Lock()
+	current := mp.binHash
+	bad := mp.badHashes[hex.EncodeToString(newHash)]
+	mp.binMux.Unlock()
+	if bytes.Equal(current, newHash) {
+		l.debugf("hash match - skip")
+		mp.emit(Event{Type: EventFetchNoUpdate, Hash: hex.EncodeToString(newHash), Duration: time.Since(t0)})
 		return
 	}
+	if bad {
+		l.debugf("previously rolled back binary (%x) - skip", newHash[:12])
+		mp.emit(Event{Type: EventFetchNoUpdate, Hash: hex.EncodeToString(newHash), Duration: time.Since(t0)})
+		return
+	}
+	//verify checksum and signature,
+	//before the binary is ever executed.
+	//both are of the file as downloaded
+	published := digest.Sum(nil)
+	if d, ok := download.(fetcher.Digester); ok {
+		published = d.Digest()
+	}
+	verified := len(mp.Config.PublicKeys) > 0
+	if cf, ok := mp.Config.Fetcher.(fetcher.ChecksumFetcher); ok {
+		sum, err := cf.FetchChecksum(mp.fetchCtx)
+		if err != nil {
+			l.warnf("failed to fetch checksum: %s", err)
+			mp.emit(Event{Type: EventVerifyFailed, Hash: hex.EncodeToString(newHash), Err: err})
+			return
+		}
+		if sum != nil && !bytes.Equal(sum, published) {
+			err := fmt.Errorf("checksum mismatch, expected %x got %x", sum, published)
+			l.warnf("%s", err)
+			mp.emit(Event{Type: EventVerifyFailed, Hash: hex.EncodeToString(newHash), Err: err})
+			return
+		}
+		verified = verified || sum != nil
+	}
+	//commands can't be sanity checked
+	if len(mp.Command) > 0 && !verified {
+		err := errors.New("no checksum configured (see Config.PublicKeys)")
+		l.warnf("unverified binary discarded: %s", err)
+		mp.emit(Event{Type: EventVerifyFailed, Hash: hex.EncodeToString(newHash), Err: err})
+		return
+	}
+	if len(mp.Config.PublicKeys) > 0 {
+		sig, err := mp.Config.Fetcher.(fetcher.SignatureFetcher).FetchSignature(mp.fetchCtx)
+		if err != nil {
+			l.warnf("failed to fetch signature: %s", err)
+			mp.emit(Event{Type: EventVerifyFailed, Hash: hex.EncodeToString(newHash), Err: err})
+			return
+		}
+		if err := verifyDigest(mp.Config.PublicKeys, published, sig); err != nil {
+			l.warnf("signature verification failed: %s", err)
+			mp.emit(Event{Type: EventVerifyFailed, Hash: hex.EncodeToString(newHash), Err: err})
+			return
+		}
+		l.debugf("signature verified")
+	}
 	//copy permissions
 	if err := chmod(tmpBin, mp.binPerms); err != nil {
-		mp.warnf("failed to make temp binary executable: %s", err)
+		l.warnf("failed to make temp binary executable: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
 		return
 	}
 	if err := chown(tmpBin, uid, gid); err != nil {
-		mp.warnf("failed to change owner of binary: %s", err)
+		l.warnf("failed to change owner of binary: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
 		return
 	}
 	if _, err := tmpBin.Stat(); err != nil {
-		mp.warnf("failed to stat temp binary: %s", err)
+		l.warnf("failed to stat temp binary: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
 		return
 	}
 	tmpBin.Close()
 	if _, err := os.Stat(tmpBinPath); err != nil {
-		mp.warnf("failed to stat temp binary by path: %s", err)
+		l.warnf("failed to stat temp binary by path: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
 		return
 	}
 	if mp.Config.PreUpgrade != nil {
 		if err := mp.Config.PreUpgrade(tmpBinPath); err != nil {
-			mp.warnf("user cancelled upgrade: %s", err)
+			l.warnf("user cancelled upgrade: %s", err)
+			mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
 			return
 		}
 	}
-	//overseer sanity check, dont replace our good binary with a non-executable file
+	//overseer sanity check, dont replace our good binary with a non-executable file.
+	//commands aren't overseer binaries, so they can't be checked
+	if len(mp.Command) == 0 && !mp.sanityCheck(l, newHash) {
+		return
+	}
+	//the binary and its hashes are also
+	//replaced by rollbacks of crashed slaves
+	if !mp.replaceBinary(l, newHash, legacy.Sum(nil), t0) {
+		return
+	}
+	//binary successfully replaced
+	if !mp.Config.NoRestartAfterFetch {
+		mp.triggerRestart()
+	}
+	//and upgrade the master process too
+	if mp.ReexecMaster && !mp.NoRestart {
+		mp.reexec()
+	}
+	//and keep fetching...
+	return
+}
+
+//replaceBinary replaces the binary with the temp binary,
+//keeping the current binary in case of a rollback
+func (mp *master) replaceBinary(l logger, newHash, newLegacyHash []byte, t0 time.Time) bool {
+	mp.binMux.Lock()
+	defer mp.binMux.Unlock()
+	if mp.RollbackWindow > 0 {
+		if err := copyFile(mp.prevBinPath, mp.binPath, mp.binPerms); err != nil {
+			l.warnf("failed to keep previous binary: %s", err)
+			mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
+			return false
+		}
+	}
+	//overwrite!
+	if err := overwrite(mp.binPath, tmpBinPath); err != nil {
+		l.warnf("failed to overwrite binary: %s", err)
+		mp.emit(Event{Type: EventFetchFailed, Hash: hex.EncodeToString(newHash), Err: err})
+		return false
+	}
+	l.with("old_hash", hex.EncodeToString(mp.binHash), "new_hash", hex.EncodeToString(newHash)).
+		debugf("upgraded binary (%x -> %x)", mp.binHash[:12], newHash[:12])
+	mp.emit(Event{
+		Type:         EventBinaryReplaced,
+		Hash:         hex.EncodeToString(newHash),
+		PreviousHash: hex.EncodeToString(mp.binHash),
+		Duration:     time.Since(t0),
+	})
+	if mp.RollbackWindow > 0 {
+		mp.prevBinHash = mp.binHash
+		mp.prevBinLegacyHash = mp.binLegacyHash
+		mp.probationUntil = time.Time{}
+	}
+	mp.binHash = newHash
+	mp.binLegacyHash = newLegacyHash
+	return true
+}
+
+//sanityCheck runs the fetched binary to confirm it is an overseer binary
+func (mp *master) sanityCheck(l logger, newHash []byte) bool {
 	tokenIn := token()
 	cmd := exec.Command(tmpBinPath)
 	cmd.Env = append(os.Environ(), []string{envBinCheck + "=" + tokenIn}...)
@@ -276,7 +615,7 @@ func (mp *master) fetch() {
 	go func() {
 		time.Sleep(5 * time.Second)
 		if !returned {
-			mp.warnf("sanity check against fetched executable timed-out, check overseer is running")
+			l.warnf("sanity check against fetched executable timed-out, check overseer is running")
 			if cmd.Process != nil {
 				cmd.Process.Kill()
 			}
@@ -285,141 +624,949 @@ func (mp *master) fetch() {
 	tokenOut, err := cmd.CombinedOutput()
 	returned = true
 	if err != nil {
-		mp.warnf("failed to run temp binary: %s (%s) output \"%s\"", err, tmpBinPath, tokenOut)
-		return
+		l.warnf("failed to run temp binary: %s (%s) output \"%s\"", err, tmpBinPath, tokenOut)
+		mp.emit(Event{Type: EventSanityCheckFailed, Hash: hex.EncodeToString(newHash), Err: err})
+		return false
 	}
 	if tokenIn != string(tokenOut) {
-		mp.warnf("sanity check failed")
-		return
-	}
-	//overwrite!
-	if err := overwrite(mp.binPath, tmpBinPath); err != nil {
-		mp.warnf("failed to overwrite binary: %s", err)
-		return
+		l.warnf("sanity check failed")
+		mp.emit(Event{Type: EventSanityCheckFailed, Hash: hex.EncodeToString(newHash), Err: errors.New("sanity check failed")})
+		return false
 	}
-	mp.debugf("upgraded binary (%x -> %x)", mp.binHash[:12], newHash[:12])
-	mp.binHash = newHash
-	//binary successfully replaced
-	if !mp.Config.NoRestartAfterFetch {
-		mp.triggerRestart()
-	}
-	//and keep fetching...
-	return
+	return true
 }
 
 func (mp *master) triggerRestart() {
+	mp.restart(false)
+}
+
+//restartStale restarts the workers which are still
+//running a binary other than the current binary
+func (mp *master) restartStale() {
+	mp.restart(true)
+}
+
+func (mp *master) restart(staleOnly bool) {
+	if !mp.running() {
+		mp.debugf("no slave process")
+		return //skip
+	} else if mp.isShuttingDown() || mp.isExiting() {
+		mp.debugf("shutting down")
+		return //skip
+	}
+	mp.restartMux.Lock()
 	if mp.restarting {
+		mp.restartMux.Unlock()
 		mp.debugf("already graceful restarting")
 		return //skip
-	} else if mp.slaveCmd == nil || mp.restarting {
-		mp.debugf("no slave process")
-		return //skip
 	}
-	mp.debugf("graceful restart triggered")
 	mp.restarting = true
-	mp.awaitingUSR1 = true
 	mp.signalledAt = time.Now()
-	mp.sendSignal(mp.Config.RestartSignal) //ask nicely to terminate
+	mp.restartMux.Unlock()
+	mp.debugf("graceful restart triggered")
+	if mp.NoRestart {
+		mp.notify("STOPPING=1")
+	} else {
+		mp.notify("RELOADING=1")
+	}
+	hash, rollbacks := mp.currentBinary()
+	mp.emit(Event{Type: EventRestartTriggered, Hash: hex.EncodeToString(hash)})
+	if mp.NoRestart {
+		mp.stopFetch()
+		//shut down all workers at once
+		mp.sendSignal(mp.drainSignal())
+		time.Sleep(mp.TerminateTimeout)
+		//times up mr. process, we did ask nicely!
+		mp.debugf("graceful timeout, forcing exit")
+		mp.killWorkers()
+		return
+	}
+	//restart workers one at a time, so the
+	//others keep serving in the meantime
+	for _, w := range mp.workers {
+		s := w.current()
+		if s == nil || (staleOnly && (s.hasExited() || bytes.Equal(s.binHash, hash))) {
+			continue
+		}
+		if mp.WaitForReady {
+			if !mp.cutover(w) {
+				break
+			}
+			continue
+		}
+		mp.restartWorker(w)
+	}
+	mp.restartMux.Lock()
+	mp.restartedAt = time.Now()
+	mp.restarting = false
+	mp.restartMux.Unlock()
+	mp.notifyReady()
+	hash, rolledBack := mp.currentBinary()
+	mp.emit(Event{
+		Type:     EventRestartCompleted,
+		Hash:     hex.EncodeToString(hash),
+		Duration: mp.restartedAt.Sub(mp.signalledAt),
+	})
+	//workers which were upgraded before a
+	//rollback need to be restarted again
+	if rolledBack != rollbacks && mp.stale() {
+		go mp.restartStale()
+	}
+}
+
+//running returns whether any worker has a slave
+func (mp *master) running() bool {
+	for _, w := range mp.workers {
+		if w.current() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+//currentBinary returns the hash of the binary, and the number
+//of rollbacks, which are also replaced by the fork goroutines
+func (mp *master) currentBinary() ([]byte, int) {
+	mp.binMux.Lock()
+	defer mp.binMux.Unlock()
+	return mp.binHash, mp.rollbacks
+}
+
+//isRestarting is also read by the signal,
+//message and control goroutines
+func (mp *master) isRestarting() bool {
+	mp.restartMux.Lock()
+	defer mp.restartMux.Unlock()
+	return mp.restarting
+}
+
+//stale returns whether any worker is running a slave
+//with a binary other than the current binary
+func (mp *master) stale() bool {
+	hash, _ := mp.currentBinary()
+	for _, w := range mp.workers {
+		if s := w.current(); s != nil && !s.hasExited() && !bytes.Equal(s.binHash, hash) {
+			return true
+		}
+	}
+	return false
+}
+
+//restartWorker asks the worker's slave to terminate,
+//the worker then starts a new slave once the old slave
+//has released its sockets
+func (mp *master) restartWorker(w *worker) {
+	old := w.current()
+	if old == nil {
+		return
+	}
+	//discard the result of a timed out restart
+	select {
+	case <-w.restarted:
+	default:
+	}
+	w.awaitRelease()
+	old.draining()
+	if err := old.cmd.Process.Signal(mp.drainSignal()); err != nil {
+		//ask nicely to terminate
+		mp.slaveLog(old).debugf("signal failed (%s), slave#%d already exited", err, old.id)
+	}
 	select {
-	case <-mp.restarted:
+	case <-w.restarted:
 		//success
 		mp.debugf("restart success")
 	case <-time.After(mp.TerminateTimeout):
 		//times up mr. process, we did ask nicely!
 		mp.debugf("graceful timeout, forcing exit")
-		mp.sendSignal(os.Kill)
+		mp.kill(old)
+	}
+}
+
+//cutover starts the new slave alongside the old slave,
+//and only once the new slave is ready, is the old slave
+//asked to terminate
+func (mp *master) cutover(w *worker) bool {
+	old := w.current()
+	w.setRestarting(true)
+	s, err := mp.startSlave(w)
+	if err != nil {
+		mp.warnf("restart failed: %s", err)
+		mp.emit(Event{Type: EventRestartFailed, Worker: w.index, Err: err})
+		w.setRestarting(false)
+		return false
+	}
+	//commands are probed from the start
+	if mp.ReadyProbe != nil && len(mp.Command) == 0 {
+		go mp.probe(s)
+	}
+	select {
+	case <-s.ready:
+		mp.slaveLog(s).debugf("slave#%d ready, shutting down slave#%d", s.id, old.id)
+	case <-s.exited:
+		mp.slaveLog(s).warnf("restart failed: slave#%d exited before becoming ready", s.id)
+	case <-time.After(mp.ReadyTimeout):
+		mp.slaveLog(s).warnf("restart failed: slave#%d not ready after %s, killing it", s.id, mp.ReadyTimeout)
+		mp.kill(s)
+	}
+	if !s.isReady() {
+		e := slaveEvent(EventRestartFailed, s)
+		e.Err = errors.New("not ready")
+		mp.emit(e)
+		//the old slave is still running the previous
+		//binary, restore it before it's restarted again
+		mp.tryRollback(s, 1)
+		w.setRestarting(false)
+		w.cutoverDone <- nil
+		return false
+	}
+	//new slave is now active
+	w.setCurrent(s)
+	w.setRestarting(false)
+	w.cutoverDone <- s
+	//ask nicely, then force the old slave to terminate
+	old.draining()
+	if err := old.cmd.Process.Signal(mp.drainSignal()); err != nil {
+		mp.slaveLog(old).debugf("signal failed (%s), slave#%d already exited", err, old.id)
+		return true
+	}
+	select {
+	case <-old.exited:
+		mp.debugf("restart success")
+	case <-time.After(mp.TerminateTimeout):
+		mp.slaveLog(old).debugf("graceful timeout, forcing exit of slave#%d", old.id)
+		mp.kill(old)
+	}
+	return true
+}
+
+//probe is run in a goroutine during a cutover
+func (mp *master) probe(s *slaveProcess) {
+	for {
+		select {
+		case <-s.ready:
+			return
+		case <-s.exited:
+			return
+		case <-time.After(250 * time.Millisecond):
+		}
+		if err := mp.ReadyProbe(s.cmd.Process.Pid); err == nil {
+			mp.handleMessage(s, msgReady, "")
+			return
+		}
 	}
 }
 
 //not a real fork
 func (mp *master) forkLoop() error {
-	//loop, restart command
-	for {
-		if err := mp.fork(); err != nil {
+	errs := make(chan error, len(mp.workers))
+	for _, w := range mp.workers {
+		go func(w *worker) {
+			//loop, restart command
+			for {
+				if err := mp.fork(w); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	//after a graceful shutdown, wait for all workers
+	for range mp.workers {
+		if err := <-errs; err != errStopped {
+			return err
+		}
+	}
+	mp.debugf("all workers stopped")
+	signal.Stop(mp.signals)
+	close(mp.stopped)
+	return nil
+}
+
+func (mp *master) fork(w *worker) error {
+	if mp.isShuttingDown() {
+		return errStopped
+	}
+	//the slave left by the previous master process
+	s := w.adopted
+	w.adopted = nil
+	if s == nil {
+		var err error
+		if s, err = mp.startSlave(w); err != nil {
 			return err
 		}
 	}
+	//mark this new process as the worker's "active" slave
+	//process. this process is assumed to be holding the socket files.
+	w.setCurrent(s)
+	//discard a release which raced with the previous slave exiting
+	select {
+	case <-w.descriptorsReleased:
+	default:
+	}
+	if s.isReady() {
+		mp.notifyReady()
+	}
+	//was scheduled to restart, notify success
+	if w.setRestarting(false) {
+		select {
+		case w.restarted <- true:
+		default:
+		}
+	}
+	//wait....
+	for {
+		select {
+		case <-s.exited:
+			//program exited before releasing descriptors
+			//proxy exit code out to master
+			code := s.exitCode()
+			mp.slaveLog(s).with("exit_code", code).debugf("prog exited with %d", code)
+			//stopped by SIGTERM or an interrupt
+			if mp.isExiting() {
+				return mp.exit(w, code)
+			}
+			//programs killed at the deadline of a graceful
+			//shutdown aren't rolled back or restarted
+			shuttingDown := mp.isShuttingDown()
+			//crashed soon after an upgrade, go back
+			//to the previous binary and start again
+			if !shuttingDown && mp.tryRollback(s, code) {
+				//this worker starts the previous binary next,
+				//only the other workers may need a restart
+				if !mp.isRestarting() && mp.stale() {
+					go mp.restartStale()
+				}
+				return nil
+			}
+			//a cutover in progress will either replace
+			//this slave, or fail and leave nothing running
+			if mp.WaitForReady && w.isRestarting() {
+				if s = <-w.cutoverDone; s != nil {
+					continue
+				}
+				return mp.exit(w, code)
+			}
+			//when supervising, crashes are restarted
+			//while the sockets remain open
+			if mp.Supervise && code != 0 && !w.isRestarting() && !shuttingDown {
+				if delay, ok := mp.crashBackoff(w, s); ok {
+					mp.slaveLog(s).with("exit_code", code).warnf("prog crashed with %d, restarting in %s", code, delay)
+					w.setCurrent(nil)
+					time.Sleep(delay)
+					return nil
+				}
+				mp.slaveLog(s).with("exit_code", code).warnf("prog crashed %d times in a row, giving up", w.crashes)
+			}
+			//if a restarts are disabled or if it was an
+			//unexpected crash, proxy this exit straight
+			//through to the main process
+			if mp.NoRestart || !w.isRestarting() {
+				return mp.exit(w, code)
+			}
+		case <-w.descriptorsReleased:
+			//if descriptors are released, the program
+			//has yielded control of its sockets and
+			//a parallel instance of the program can be
+			//started safely. it should serve state.Listeners
+			//to ensure downtime is kept at <1sec. The previous
+			//cmd.Wait() will still be consumed though the
+			//result will be discarded.
+		case next := <-w.cutoverDone:
+			//the new slave is now active, the old slave
+			//will be waited on by the cutover
+			if next != nil {
+				s = next
+			}
+			continue
+		}
+		return nil
+	}
+}
+
+//exit proxies the exit code through to the main process
+//once the remaining workers have shut down as well
+func (mp *master) exit(w *worker, code int) error {
+	mp.exitMux.Lock()
+	w.setCurrent(nil)
+	//graceful shutdowns return from run instead
+	if mp.shuttingDown {
+		mp.exitMux.Unlock()
+		return errStopped
+	}
+	if mp.exitCode == 0 {
+		mp.exitCode = code
+	}
+	running := mp.running()
+	if running && !mp.exiting {
+		mp.debugf("shutting down remaining workers")
+		mp.sendSignal(mp.drainSignal())
+	}
+	mp.exiting = true
+	mp.stopFetch()
+	mp.exitMux.Unlock()
+	if running {
+		select {} //the last worker exits
+	}
+	mp.removeConnsDir()
+	os.Exit(mp.exitCode)
+	return nil
+}
+
+//shutdown asks all slaves to shut down gracefully, and once
+//they have exited, run returns. when ctx is done first, the
+//slaves are killed.
+func (mp *master) shutdown(ctx context.Context) error {
+	mp.exitMux.Lock()
+	if !mp.shuttingDown {
+		mp.debugf("graceful shutdown")
+		mp.emit(Event{Type: EventShutdown})
+		mp.shuttingDown = true
+		mp.notify("STOPPING=1")
+		mp.stopFetch()
+		mp.signalWorkers(mp.drainSignal())
+	}
+	mp.exitMux.Unlock()
+	select {
+	case <-mp.stopped:
+		return nil
+	case <-ctx.Done():
+		mp.debugf("graceful shutdown timeout, forcing exit")
+		mp.killWorkers()
+		<-mp.stopped
+		return ctx.Err()
+	}
+}
+
+func (mp *master) shutdownTimeout(d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	mp.shutdown(ctx)
+}
+
+func (mp *master) isShuttingDown() bool {
+	mp.exitMux.Lock()
+	defer mp.exitMux.Unlock()
+	return mp.shuttingDown
 }
 
-func (mp *master) fork() error {
-	mp.debugf("starting %s", mp.binPath)
+//isExiting returns whether the master process exits once
+//its programs have, see exit
+func (mp *master) isExiting() bool {
+	mp.exitMux.Lock()
+	defer mp.exitMux.Unlock()
+	return mp.exiting
+}
+
+//signalWorkers is sendSignal, except slaves which
+//have already exited are ignored
+func (mp *master) signalWorkers(s os.Signal) {
+	for _, w := range mp.workers {
+		if sp := w.current(); sp != nil {
+			if s == mp.drainSignal() {
+				sp.draining()
+			}
+			sp.cmd.Process.Signal(s)
+		}
+	}
+}
+
+//kill forces the slave to exit
+func (mp *master) kill(s *slaveProcess) {
+	if err := s.cmd.Process.Kill(); err == nil {
+		mp.emit(slaveEvent(EventForcedKill, s))
+	}
+}
+
+func (mp *master) killWorkers() {
+	for _, w := range mp.workers {
+		if s := w.current(); s != nil {
+			mp.kill(s)
+		}
+	}
+}
+
+//crashBackoff returns how long to wait before restarting
+//the crashed slave, or false once the crash limit is reached
+func (mp *master) crashBackoff(w *worker, s *slaveProcess) (time.Duration, bool) {
+	//long running slaves reset the backoff
+	if time.Since(s.startedAt) > mp.CrashBackoffMax {
+		w.crashes = 0
+	}
+	w.crashes++
+	if mp.CrashLimit > 0 && w.crashes > mp.CrashLimit {
+		return 0, false
+	}
+	delay := mp.CrashBackoff
+	for i := 1; i < w.crashes && delay < mp.CrashBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > mp.CrashBackoffMax {
+		delay = mp.CrashBackoffMax
+	}
+	//+/- 20% jitter
+	jitter := time.Duration(mathrand.Int63n(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter, true
+}
+
+//tryRollback restores the previous binary when the slave crashed
+//soon after an upgrade, marking the upgraded binary as bad. it
+//returns whether the slave's worker should start over, which is
+//also the case once its binary has already been rolled back.
+func (mp *master) tryRollback(s *slaveProcess, code int) bool {
+	mp.binMux.Lock()
+	defer mp.binMux.Unlock()
+	if code == 0 {
+		return false
+	}
+	//another slave already crashed with this binary
+	if mp.badHashes[hex.EncodeToString(s.binHash)] {
+		return true
+	}
+	if mp.prevBinHash == nil ||
+		!bytes.Equal(s.binHash, mp.binHash) ||
+		!s.startedAt.Before(mp.probationUntil) {
+		return false
+	}
+	if err := mp.restorePrevious(mp.slaveLog(s).with("exit_code", code), code); err != nil {
+		mp.warnf("rollback failed: %s", err)
+		return false
+	}
+	return true
+}
+
+//rollback restores the previous binary on request,
+//and restarts the programs
+func (mp *master) rollback() error {
+	if mp.isRestarting() {
+		return errors.New("already restarting")
+	}
+	mp.binMux.Lock()
+	if mp.prevBinHash == nil {
+		mp.binMux.Unlock()
+		return errors.New("no previous binary (see Config.RollbackWindow)")
+	}
+	err := mp.restorePrevious(mp.logger(), 0)
+	mp.binMux.Unlock()
+	if err != nil {
+		return fmt.Errorf("rollback failed (%s)", err)
+	}
+	go mp.triggerRestart()
+	return nil
+}
+
+//restorePrevious replaces the binary with the previous binary,
+//marking the current binary as bad. binMux must be held.
+func (mp *master) restorePrevious(l logger, code int) error {
+	if err := overwrite(mp.binPath, mp.prevBinPath); err != nil {
+		return err
+	}
+	l.with("old_hash", hex.EncodeToString(mp.binHash), "new_hash", hex.EncodeToString(mp.prevBinHash)).
+		warnf("rolled back binary (%x -> %x)", mp.binHash[:12], mp.prevBinHash[:12])
+	mp.emit(Event{
+		Type:         EventRollback,
+		Hash:         hex.EncodeToString(mp.prevBinHash),
+		PreviousHash: hex.EncodeToString(mp.binHash),
+		ExitCode:     code,
+	})
+	mp.badHashes[hex.EncodeToString(mp.binHash)] = true
+	mp.binHash = mp.prevBinHash
+	mp.binLegacyHash = mp.prevBinLegacyHash
+	mp.prevBinHash = nil
+	mp.rollbacks++
+	return nil
+}
+
+//startSlave starts a new slave process, without waiting for it
+func (mp *master) startSlave(w *worker) (*slaveProcess, error) {
+	mp.binMux.Lock()
 	cmd := exec.Command(mp.binPath)
-	//mark this new process as the "active" slave process.
-	//this process is assumed to be holding the socket files.
-	mp.slaveCmd = cmd
 	mp.slaveID++
+	s := newSlaveProcess(mp.slaveID, w.index, cmd, mp.binHash)
+	//the first slave of an upgrade starts the probation period
+	if mp.prevBinHash != nil && mp.probationUntil.IsZero() {
+		mp.probationUntil = s.startedAt.Add(mp.RollbackWindow)
+	}
+	legacyHash := mp.binLegacyHash
+	mp.binMux.Unlock()
+	mp.slaveLog(s).debugf("starting %s", mp.binPath)
+	if len(mp.Command) > 0 {
+		return mp.startCommand(s, w)
+	}
 	//provide the slave process with some state
 	e := os.Environ()
-	e = append(e, envBinID+"="+hex.EncodeToString(mp.binHash))
+	e = append(e, envBinID+"="+hex.EncodeToString(s.binHash))
+	e = append(e, envBinLegacyID+"="+hex.EncodeToString(legacyHash))
 	e = append(e, envBinPath+"="+mp.binPath)
-	e = append(e, envSlaveID+"="+strconv.Itoa(mp.slaveID))
+	e = append(e, envSlaveID+"="+strconv.Itoa(s.id))
 	e = append(e, envIsSlave+"=1")
-	e = append(e, envNumFDs+"="+strconv.Itoa(len(mp.slaveExtraFiles)))
+	e = append(e, envWorkerIndex+"="+strconv.Itoa(w.index))
+	e = append(e, envWorkerCount+"="+strconv.Itoa(len(mp.workers)))
+	e = append(e, envNumFDs+"="+strconv.Itoa(len(mp.Config.Addresses)))
+	e = append(e, envNumPacketFDs+"="+strconv.Itoa(len(mp.Config.PacketAddresses)))
+	e = append(e, envFDNames+"="+strings.Join(mp.slaveFDNames, ":"))
+	if mp.notifier != nil && mp.notifier.watchdog > 0 {
+		e = append(e, envHeartbeat+"="+(mp.notifier.watchdog/4).String())
+	}
+	//the previous slave of this worker hands off to this slave
+	prev := w.current()
+	if mp.connsDir != "" {
+		e = append(e, envConnsSocket+"="+mp.connsSocket(w))
+	}
+	//include socket files
+	cmd.ExtraFiles = mp.slaveExtraFiles
+	//and the message pipe, after the sockets
+	pipeR, pipeW, err := openPipe()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create slave pipe: %s", err)
+	}
+	if pipeW != nil {
+		e = append(e, envPipeFD+"="+strconv.Itoa(3+len(cmd.ExtraFiles)))
+		if mp.metrics != nil {
+			e = append(e, envStatsInterval+"=5s")
+		}
+		cmd.ExtraFiles = append(append([]*os.File{}, cmd.ExtraFiles...), pipeW)
+	}
+	//and the inherited pipe, after the message pipe
+	var inheritR, inheritW *os.File
+	if pipeW != nil {
+		if inheritR, inheritW, err = os.Pipe(); err != nil {
+			pipeR.Close()
+			pipeW.Close()
+			return nil, fmt.Errorf("Failed to create slave pipe: %s", err)
+		}
+		s.handoffPath = handoffPath()
+		e = append(e, envHandoffPath+"="+s.handoffPath)
+		e = append(e, envInheritedFD+"="+strconv.Itoa(3+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, inheritR)
+	}
 	cmd.Env = e
 	//inherit master args/stdfiles
 	cmd.Args = os.Args
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	//include socket files
-	cmd.ExtraFiles = mp.slaveExtraFiles
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("Failed to start slave process: %s", err)
+	err = cmd.Start()
+	if pipeW != nil {
+		pipeW.Close()
+		inheritR.Close()
+		if err != nil {
+			pipeR.Close()
+			inheritW.Close()
+		}
 	}
-	//was scheduled to restart, notify success
-	if mp.restarting {
-		mp.restartedAt = time.Now()
-		mp.restarting = false
-		mp.restarted <- true
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start slave process: %s", err)
 	}
-	//convert wait into channel
-	cmdwait := make(chan error)
-	go func() {
-		cmdwait <- cmd.Wait()
-	}()
-	//wait....
-	select {
-	case err := <-cmdwait:
-		//program exited before releasing descriptors
-		//proxy exit code out to master
-		code := 0
+	s.pipe = pipeR
+	mp.trackSlave(s)
+	mp.emit(slaveEvent(EventSlaveStarted, s))
+	go mp.wait(s)
+	if pipeR != nil {
+		go mp.readPipe(s, pipeR)
+		mp.handoffs.Add(1)
+		go mp.handoff(prev, s, inheritW)
+	} else {
+		//no way to hear from the slave, assume its ready
+		mp.handleMessage(s, msgReady, "")
+	}
+	return s, nil
+}
+
+//wait converts cmd.Wait into the exited channel
+func (mp *master) wait(s *slaveProcess) {
+	if s.adopted {
+		s.err = waitAdopted(s)
+	} else {
+		s.err = s.cmd.Wait()
+	}
+	//messages sent before exiting
+	if s.pipe != nil {
+		select {
+		case <-s.pipeClosed:
+		case <-time.After(time.Second):
+		}
+	}
+	//discard an incomplete handoff
+	s.handoffOnce.Do(func() {
+		if s.handoffPath != "" {
+			os.Remove(s.handoffPath)
+		}
+	})
+	mp.untrackSlave(s)
+	close(s.exited)
+	mp.metrics.slaveExited(s)
+	e := slaveEvent(EventSlaveExited, s)
+	e.ExitCode = s.exitCode()
+	e.Duration = time.Since(s.startedAt)
+	mp.emit(e)
+}
+
+func (mp *master) handleMessage(s *slaveProcess, msg, args string) {
+	switch msg {
+	case msgReady:
+		mp.slaveLog(s).debugf("slave#%d ready", s.id)
+		if !s.isReady() {
+			mp.emit(slaveEvent(EventSlaveReady, s))
+		}
+		s.markReady()
+		for _, w := range mp.workers {
+			if w.current() == s {
+				mp.notifyReady()
+			}
+		}
+	case msgShutdown:
+		d, err := time.ParseDuration(args)
 		if err != nil {
-			code = 1
-			if exiterr, ok := err.(*exec.ExitError); ok {
-				if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
-					code = status.ExitStatus()
-				}
+			d = mp.TerminateTimeout
+		}
+		mp.slaveLog(s).debugf("slave#%d requested shutdown", s.id)
+		go mp.shutdownTimeout(d)
+	case msgReleased:
+		for _, w := range mp.workers {
+			if w.release(s) {
+				mp.slaveLog(s).debugf("slave#%d released sockets", s.id)
 			}
 		}
-		mp.debugf("prog exited with %d", code)
-		//if a restarts are disabled or if it was an
-		//unexpected crash, proxy this exit straight
-		//through to the main process
-		if mp.NoRestart || !mp.restarting {
-			os.Exit(code)
-		}
-	case <-mp.descriptorsReleased:
-		//if descriptors are released, the program
-		//has yielded control of its sockets and
-		//a parallel instance of the program can be
-		//started safely. it should serve state.Listeners
-		//to ensure downtime is kept at <1sec. The previous
-		//cmd.Wait() will still be consumed though the
-		//result will be discarded.
+	case msgHandoff:
+		mp.slaveLog(s).debugf("slave#%d handed off", s.id)
+		mp.handedOff(s)
+	case msgStats:
+		if err := mp.metrics.connStats(s, args); err != nil {
+			mp.slaveLog(s).debugf("slave#%d sent %s", s.id, err)
+		}
+	case msgAlive:
+		atomic.StoreInt64(&mp.aliveAt, time.Now().UnixNano())
+	default:
+		mp.slaveLog(s).debugf("slave#%d sent unknown message: %s", s.id, msg)
 	}
-	return nil
 }
 
-func (mp *master) debugf(f string, args ...interface{}) {
-	if mp.Config.Debug {
-		log.Printf("[overseer master] "+f, args...)
+func (mp *master) notifyReady() {
+	mp.notify("READY=1\nMAINPID=" + strconv.Itoa(os.Getpid()))
+}
+
+func (mp *master) notify(state string) {
+	if err := mp.notifier.notify(state); err != nil {
+		mp.warnf("systemd notify failed: %s", err)
+	}
+}
+
+//watchdogLoop is run in a goroutine, it pings the
+//systemd watchdog while the slave is still alive
+func (mp *master) watchdogLoop() {
+	interval := mp.notifier.watchdog / 2
+	for range time.Tick(interval) {
+		//slaves without a pipe cant send heartbeats,
+		//and restarts may briefly interrupt them
+		last := atomic.LoadInt64(&mp.aliveAt)
+		if last == 0 || mp.isRestarting() || time.Since(time.Unix(0, last)) < interval {
+			mp.notify("WATCHDOG=1")
+		} else {
+			mp.warnf("slave unresponsive, skipped watchdog ping")
+		}
 	}
 }
 
+func (mp *master) logger() logger {
+	return logger{config: mp.Config, prefix: "[overseer master] ", attrs: []interface{}{"process", "master"}}
+}
+
+//slaveLog includes the slave's attributes
+func (mp *master) slaveLog(s *slaveProcess) logger {
+	return mp.logger().with("slave_id", s.id, "bin_hash", hex.EncodeToString(s.binHash))
+}
+
+func (mp *master) debugf(f string, args ...interface{}) {
+	mp.logger().debugf(f, args...)
+}
+
 func (mp *master) warnf(f string, args ...interface{}) {
-	if mp.Config.Debug || !mp.Config.NoWarn {
-		log.Printf("[overseer master] "+f, args...)
+	mp.logger().warnf(f, args...)
+}
+
+//a worker runs one slave process at a time,
+//all workers share the same sockets
+type worker struct {
+	index int
+	//mut guards slave, restarting and awaitingRelease, which are
+	//also used by the signal, message and control goroutines
+	mut                 sync.Mutex
+	slave               *slaveProcess
+	restarting          bool
+	awaitingRelease     bool
+	restarted           chan bool
+	cutoverDone         chan *slaveProcess
+	descriptorsReleased chan bool
+	crashes             int
+	adopted             *slaveProcess
+}
+
+//current returns the worker's active slave, or nil
+func (w *worker) current() *slaveProcess {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.slave
+}
+
+//setCurrent marks s as the worker's active slave
+func (w *worker) setCurrent(s *slaveProcess) {
+	w.mut.Lock()
+	w.slave = s
+	w.awaitingRelease = false
+	w.mut.Unlock()
+}
+
+func (w *worker) isRestarting() bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.restarting
+}
+
+//setRestarting returns the previous value
+func (w *worker) setRestarting(restarting bool) bool {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	was := w.restarting
+	w.restarting = restarting
+	return was
+}
+
+//awaitRelease is called before asking the slave to shut down
+func (w *worker) awaitRelease() {
+	w.mut.Lock()
+	w.restarting = true
+	w.awaitingRelease = true
+	w.mut.Unlock()
+}
+
+//release notifies the worker that s (or its active slave,
+//when nil) has released the sockets, if it was awaited
+func (w *worker) release(s *slaveProcess) bool {
+	w.mut.Lock()
+	ok := w.awaitingRelease && (s == nil || w.slave == s)
+	if ok {
+		w.awaitingRelease = false
+	}
+	w.mut.Unlock()
+	if ok {
+		w.released()
+	}
+	return ok
+}
+
+//released notifies the worker that its slave has
+//released the sockets, unless it has already exited
+func (w *worker) released() {
+	select {
+	case w.descriptorsReleased <- true:
+	default:
+	}
+}
+
+//a slave process, as seen by the master
+type slaveProcess struct {
+	id        int
+	worker    int
+	cmd       *exec.Cmd
+	binHash   []byte
+	startedAt time.Time
+	ready     chan bool
+	readyOnce sync.Once
+	exited    chan bool
+	err       error
+	//when the slave was asked to shut down
+	drainAt int64
+	//read end of the message pipe
+	pipe       *os.File
+	pipeClosed chan bool
+	//closed when asked to shut down
+	drain     chan bool
+	drainOnce sync.Once
+	//see State.Handoff
+	handoffPath string
+	handoffData []byte
+	handedOff   chan bool
+	handoffOnce sync.Once
+	//started by the previous master process
+	adopted bool
+}
+
+func newSlaveProcess(id, worker int, cmd *exec.Cmd, binHash []byte) *slaveProcess {
+	return &slaveProcess{
+		id:         id,
+		worker:     worker,
+		cmd:        cmd,
+		binHash:    binHash,
+		startedAt:  time.Now(),
+		ready:      make(chan bool),
+		exited:     make(chan bool),
+		pipeClosed: make(chan bool),
+		drain:      make(chan bool),
+		handedOff:  make(chan bool),
+	}
+}
+
+func (s *slaveProcess) markReady() {
+	s.readyOnce.Do(func() {
+		close(s.ready)
+	})
+}
+
+//draining records when the slave was first asked to shut down
+func (s *slaveProcess) draining() {
+	atomic.CompareAndSwapInt64(&s.drainAt, 0, time.Now().UnixNano())
+	s.drainOnce.Do(func() {
+		close(s.drain)
+	})
+}
+
+func (s *slaveProcess) hasExited() bool {
+	select {
+	case <-s.exited:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *slaveProcess) isReady() bool {
+	select {
+	case <-s.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+//exitCode is only valid once exited
+func (s *slaveProcess) exitCode() int {
+	if s.err == nil {
+		return 0
+	}
+	if exiterr, ok := s.err.(*exec.ExitError); ok {
+		if status, ok := exiterr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return 1
+}
+
+//copyFile replaces dst with a copy of src
+func copyFile(dst, src string, perms os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perms)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
 	}
+	return out.Close()
 }
 
 func token() string {
@@ -1,12 +1,16 @@
 package overseer
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,8 +27,13 @@ type State struct {
 	//this program will be running in a child process and
 	//overseer will perform rolling upgrades.
 	Enabled bool
-	//ID is a SHA-1 hash of the current running binary
+	//ID is a hash of the current running binary, using
+	//Config.HashAlgorithm (SHA-256 by default)
 	ID string
+	//LegacyID is the SHA-1 hash of the current running binary,
+	//which was previously used as the ID.
+	//Deprecated: use ID.
+	LegacyID string
 	//StartedAt records the start time of the program
 	StartedAt time.Time
 	//Listener is the first net.Listener in Listeners
@@ -32,27 +41,86 @@ type State struct {
 	//Listeners are the set of acquired sockets by the master
 	//process. These are all passed into this program in the
 	//same order they are specified in Config.Addresses.
+	//Depending on the address, these are TCP or unix listeners,
+	//wrapped in a *Listener which tracks their connections, and
+	//TLS listeners for addresses in Config.TLS.
 	Listeners []net.Listener
+	//ListenersByName contains the Listeners which were passed
+	//to the master process by systemd socket activation, keyed
+	//by their FileDescriptorName= (see systemd.socket(5)).
+	ListenersByName map[string]net.Listener
 	//Program's first listening address
 	Address string
 	//Program's listening addresses
 	Addresses []string
+	//PacketConns are the set of acquired packet sockets by
+	//the master process, in the same order they are specified
+	//in Config.PacketAddresses. When a graceful shutdown is
+	//requested, ReadFrom will return ErrPacketConnReleased
+	//as the next program may already be reading from them.
+	//Writes continue to succeed until this program exits.
+	PacketConns []net.PacketConn
+	//Program's packet addresses
+	PacketAddresses []string
 	//GracefulShutdown will be filled when its time to perform
 	//a graceful shutdown.
 	GracefulShutdown chan bool
 	//Path of the binary currently being executed
 	BinPath string
+	//WorkerIndex is the index of this program's worker,
+	//from 0 to WorkerCount-1 (see Config.Workers)
+	WorkerIndex int
+	//WorkerCount is the number of programs running in parallel
+	WorkerCount int
+	//Handoff passes state, such as caches or sessions, to the
+	//next program of this worker. Write to it after GracefulShutdown
+	//is closed, then Close it to complete the handoff (which also
+	//happens when the program returns). The handoff is discarded
+	//if this program exits early, or exceeds Config.HandoffLimit.
+	//Writes fail on unsupported operating systems.
+	Handoff io.WriteCloser
+	//Inherited reads the Handoff of the previous program of this
+	//worker. Reads block until the previous program has closed its
+	//Handoff, then return EOF. Inherited is empty for the first
+	//program, and after Config.HandoffTimeout. With WaitForReady,
+	//the previous program is only asked to shut down once this
+	//program is ready, so call Ready before reading.
+	Inherited io.Reader
+	//ResumedConns receives the connections which the previous
+	//program passed to State.HandoffConn. It is nil unless
+	//Config.HandoffConns is set, and is never closed.
+	ResumedConns <-chan ResumedConn
+	//signals readiness to the master
+	ready func()
+	//see HandoffConn
+	handoffConn func(conn net.Conn, metadata []byte) error
+}
+
+//Ready signals to the master process that this program is serving.
+//With Config.WaitForReady, the previous program is only shut down
+//once this has been called. Otherwise, the program is assumed to be
+//ready as soon as it starts, and calling Ready has no effect.
+func (s State) Ready() {
+	if s.ready != nil {
+		s.ready()
+	}
 }
 
 //a overseer slave process
 
 type slave struct {
 	*Config
-	id         string
-	listeners  []*overseerListener
-	masterPid  int
-	masterProc *os.Process
-	state      State
+	id            string
+	listeners     []*Listener
+	packetConns   []*overseerPacketConn
+	masterPid     int
+	masterProc    *os.Process
+	pipe          *os.File
+	pipeMux       sync.Mutex
+	readyOnce     sync.Once
+	handoff       *handoffWriter
+	connsListener *net.UnixListener
+	state         State
 }
 
 func (sp *slave) run() error {
@@ -60,31 +128,73 @@ func (sp *slave) run() error {
 	sp.debugf("run")
 	sp.state.Enabled = true
 	sp.state.ID = os.Getenv(envBinID)
+	sp.state.LegacyID = os.Getenv(envBinLegacyID)
 	sp.state.StartedAt = time.Now()
 	sp.state.Address = sp.Config.Address
 	sp.state.Addresses = sp.Config.Addresses
+	sp.state.PacketAddresses = sp.Config.PacketAddresses
 	sp.state.GracefulShutdown = make(chan bool, 1)
 	sp.state.BinPath = os.Getenv(envBinPath)
+	sp.state.WorkerIndex, _ = strconv.Atoi(os.Getenv(envWorkerIndex))
+	sp.state.WorkerCount, _ = strconv.Atoi(os.Getenv(envWorkerCount))
+	sp.state.ready = sp.ready
 	if err := sp.watchParent(); err != nil {
 		return err
 	}
 	if err := sp.initFileDescriptors(); err != nil {
 		return err
 	}
+	sp.inheritPipe()
+	sp.initHandoff()
+	if err := sp.listenConns(); err != nil {
+		return err
+	}
 	sp.watchSignal()
+	if d, err := time.ParseDuration(os.Getenv(envHeartbeat)); err == nil {
+		go sp.heartbeat(d)
+	}
+	stats := false
+	if d, err := time.ParseDuration(os.Getenv(envStatsInterval)); err == nil && len(sp.listeners) > 0 {
+		stats = true
+		go sp.reportStats(d)
+	}
 	//run program with state
 	sp.debugf("start program")
+	if !sp.WaitForReady {
+		sp.ready()
+	}
 	sp.Config.Program(sp.state)
+	sp.waitConns()
+	sp.handoff.Close()
+	if stats {
+		sp.sendStats()
+	}
 	return nil
 }
 
+func (sp *slave) ready() {
+	sp.readyOnce.Do(func() {
+		sp.debugf("ready")
+		sp.send(msgReady)
+	})
+}
+
+//heartbeat is run in a goroutine, it lets the master
+//process know this process is still alive
+func (sp *slave) heartbeat(d time.Duration) {
+	for {
+		sp.send(msgAlive)
+		time.Sleep(d)
+	}
+}
+
 func (sp *slave) initFileDescriptors() error {
 	//inspect file descriptors
 	numFDs, err := strconv.Atoi(os.Getenv(envNumFDs))
 	if err != nil {
 		return fmt.Errorf("invalid %s integer", envNumFDs)
 	}
-	sp.listeners = make([]*overseerListener, numFDs)
+	sp.listeners = make([]*Listener, numFDs)
 	sp.state.Listeners = make([]net.Listener, numFDs)
 	for i := 0; i < numFDs; i++ {
 		f := os.NewFile(uintptr(3+i), "")
@@ -92,36 +202,82 @@ func (sp *slave) initFileDescriptors() error {
 		if err != nil {
 			return fmt.Errorf("failed to inherit file descriptor: %d", i)
 		}
-		u := newOverseerListener(l)
+		u := NewListener(l)
+		if i < len(sp.Addresses) {
+			if a, err := parseAddress(sp.Addresses[i]); err == nil {
+				if a.keepAlive != 0 {
+					u.KeepAlive = a.keepAlive
+				}
+				u.ProxyProtocol = a.proxy
+			}
+		}
 		sp.listeners[i] = u
 		sp.state.Listeners[i] = u
 	}
+	if err := sp.initTLS(); err != nil {
+		return err
+	}
 	if len(sp.state.Listeners) > 0 {
 		sp.state.Listener = sp.state.Listeners[0]
 	}
+	sp.state.ListenersByName = map[string]net.Listener{}
+	for i, name := range strings.Split(os.Getenv(envFDNames), ":") {
+		if name != "" && i < numFDs {
+			sp.state.ListenersByName[name] = sp.state.Listeners[i]
+		}
+	}
+	//packet conns follow the listeners
+	numPacketFDs, err := strconv.Atoi(os.Getenv(envNumPacketFDs))
+	if err != nil {
+		return fmt.Errorf("invalid %s integer", envNumPacketFDs)
+	}
+	sp.packetConns = make([]*overseerPacketConn, numPacketFDs)
+	sp.state.PacketConns = make([]net.PacketConn, numPacketFDs)
+	for i := 0; i < numPacketFDs; i++ {
+		f := os.NewFile(uintptr(3+numFDs+i), "")
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return fmt.Errorf("failed to inherit packet file descriptor: %d", i)
+		}
+		u := newOverseerPacketConn(c)
+		sp.packetConns[i] = u
+		sp.state.PacketConns[i] = u
+	}
 	return nil
 }
 
 func (sp *slave) watchSignal() {
-	signals := make(chan os.Signal)
+	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, sp.Config.RestartSignal)
 	go func() {
 		<-signals
 		signal.Stop(signals)
 		sp.debugf("graceful shutdown requested")
+		//the next program receives handed off connections
+		sp.closeConns()
 		//master wants to restart,
 		close(sp.state.GracefulShutdown)
 		//release any sockets and notify master
-		if len(sp.listeners) > 0 {
+		if len(sp.listeners) > 0 || len(sp.packetConns) > 0 {
+			//stop reading packets, the next program
+			//will read them from the same sockets
+			for _, c := range sp.packetConns {
+				c.release()
+			}
 			//perform graceful shutdown
 			for _, l := range sp.listeners {
-				l.release(sp.Config.TerminateTimeout)
+				l.Drain(sp.Config.TerminateTimeout)
 			}
 			//signal release of held sockets, allows master to start
 			//a new process before this child has actually exited.
-			//early restarts not supported with restarts disabled.
-			if !sp.NoRestart {
-				sp.masterProc.Signal(SIGUSR1)
+			//early restarts not supported with restarts disabled,
+			//and not required when the new process is already running.
+			if !sp.NoRestart && !sp.WaitForReady {
+				if sp.pipe != nil {
+					sp.send(msgReleased)
+				} else {
+					sp.masterProc.Signal(SIGUSR1)
+				}
 			}
 			//listeners should be waiting on connections to close...
 		}
@@ -129,25 +285,70 @@ func (sp *slave) watchSignal() {
 		go func() {
 			time.Sleep(sp.Config.TerminateTimeout)
 			sp.debugf("timeout. forceful shutdown")
+			for _, l := range sp.listeners {
+				l.ForceClose()
+			}
+			sp.sendStats()
 			os.Exit(1)
 		}()
 	}()
 }
 
+//waitConns lets the connections of drained listeners finish
+//once the program returns, until the TerminateTimeout
+func (sp *slave) waitConns() {
+	select {
+	case <-sp.state.GracefulShutdown:
+	default:
+		//not shutting down
+		return
+	}
+	for _, l := range sp.listeners {
+		l.Wait()
+	}
+}
+
 func (sp *slave) triggerRestart() {
 	if err := sp.masterProc.Signal(sp.Config.RestartSignal); err != nil {
 		os.Exit(1)
 	}
 }
 
-func (sp *slave) debugf(f string, args ...interface{}) {
-	if sp.Config.Debug {
-		log.Printf("[overseer slave#"+sp.id+"] "+f, args...)
+func (sp *slave) shutdown(ctx context.Context) error {
+	if sp.pipe != nil {
+		msg := msgShutdown
+		if deadline, ok := ctx.Deadline(); ok {
+			msg += " " + time.Until(deadline).String()
+		}
+		sp.send(msg)
+	} else if sp.Config.ShutdownSignal != nil {
+		if err := sp.masterProc.Signal(sp.Config.ShutdownSignal); err != nil {
+			return err
+		}
+	} else {
+		return errors.New("shutdown requires a ShutdownSignal on this os")
+	}
+	select {
+	case <-sp.state.GracefulShutdown:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-func (sp *slave) warnf(f string, args ...interface{}) {
-	if sp.Config.Debug || !sp.Config.NoWarn {
-		log.Printf("[overseer slave#"+sp.id+"] "+f, args...)
+func (sp *slave) logger() logger {
+	id, _ := strconv.Atoi(sp.id)
+	return logger{
+		config: sp.Config,
+		prefix: "[overseer slave#" + sp.id + "] ",
+		attrs:  []interface{}{"process", "slave", "slave_id", id, "bin_hash", sp.state.ID},
 	}
 }
+
+func (sp *slave) debugf(f string, args ...interface{}) {
+	sp.logger().debugf(f, args...)
+}
+
+func (sp *slave) warnf(f string, args ...interface{}) {
+	sp.logger().warnf(f, args...)
+}
@@ -0,0 +1,268 @@
+package overseer
+
+//listeners behind load balancers can parse the PROXY protocol
+//(see haproxy.org/download/2.0/doc/proxy-protocol.txt), v1 and
+//v2 headers are detected. the header is read by the connection's
+//first Read or RemoteAddr, so Accept isn't blocked by slow clients.
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//DefaultProxyTimeout is how long to wait for a PROXY protocol header
+const DefaultProxyTimeout = 5 * time.Second
+
+//ProxyProtocol configures PROXY protocol parsing on a Listener,
+//so the RemoteAddr of connections is the original client's.
+type ProxyProtocol struct {
+	//Trusted sources which must send a header. Connections from
+	//other sources are left as is. Required, no sources are trusted
+	//when empty (0.0.0.0/0 and ::/0 trust all TCP sources).
+	Trusted []*net.IPNet
+	//Timeout for reading the header. Defaults to DefaultProxyTimeout.
+	Timeout time.Duration
+}
+
+//trusts returns whether connections from addr are proxied
+func (p *ProxyProtocol) trusts(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range p.Trusted {
+		if n.Contains(tcp.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//parseTrusted parses comma separated CIDRs or IPs
+func parseTrusted(s string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, c := range strings.Split(s, ",") {
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader = errors.New("overseer: invalid PROXY protocol header")
+)
+
+//proxyConn reads the PROXY protocol header before any data
+type proxyConn struct {
+	net.Conn
+	timeout time.Duration
+	r       *bufio.Reader
+	once    sync.Once
+	err     error
+	//from the header, nil when not proxied (LOCAL or UNKNOWN)
+	remote, local net.Addr
+	//set by the program before the header was read
+	mut          sync.Mutex
+	readDeadline time.Time
+}
+
+func newProxyConn(c net.Conn, p *ProxyProtocol) *proxyConn {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultProxyTimeout
+	}
+	return &proxyConn{Conn: c, timeout: timeout}
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mut.Lock()
+	c.readDeadline = t
+	c.mut.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	c.mut.Lock()
+	c.readDeadline = t
+	c.mut.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+//SyscallConn allows State.HandoffConn once nothing
+//but the header has been read from the connection
+func (c *proxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok || c.r == nil || c.r.Buffered() > 0 {
+		return nil, errors.New("overseer: connection has buffered data")
+	}
+	return sc.SyscallConn()
+}
+
+func (c *proxyConn) readHeader() {
+	c.mut.Lock()
+	deadline := c.readDeadline
+	c.mut.Unlock()
+	d := time.Now().Add(c.timeout)
+	if !deadline.IsZero() && deadline.Before(d) {
+		d = deadline
+	}
+	c.Conn.SetReadDeadline(d)
+	c.r = bufio.NewReader(c.Conn)
+	c.err = c.parse()
+	c.Conn.SetReadDeadline(deadline)
+	if c.err != nil && c.err != errProxyHeader {
+		c.err = fmt.Errorf("overseer: failed to read PROXY protocol header (%s)", c.err)
+	}
+}
+
+func (c *proxyConn) parse() error {
+	b, err := c.r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(b, proxyV1Prefix) {
+		return c.parseV1()
+	}
+	if b, err = c.r.Peek(len(proxyV2Sig)); err != nil {
+		return err
+	} else if bytes.Equal(b, proxyV2Sig) {
+		return c.parseV2()
+	}
+	return errProxyHeader
+}
+
+//parseV1 parses "PROXY TCP4 src dst sport dport\r\n"
+func (c *proxyConn) parseV1() error {
+	//at most 107 bytes
+	line := make([]byte, 0, 107)
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		} else if len(line) == cap(line) {
+			return errProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return errProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	} else if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return errProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	sport, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dport, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return errProxyHeader
+	}
+	c.remote = &net.TCPAddr{IP: src, Port: int(sport)}
+	c.local = &net.TCPAddr{IP: dst, Port: int(dport)}
+	return nil
+}
+
+//parseV2 parses the binary header, skipping any TLVs
+func (c *proxyConn) parseV2() error {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	verCmd, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return err
+	}
+	if verCmd>>4 != 2 {
+		return errProxyHeader
+	}
+	switch verCmd & 0xf {
+	case 0:
+		//LOCAL, such as health checks
+		return nil
+	case 1:
+		//PROXY
+	default:
+		return errProxyHeader
+	}
+	var ipLen int
+	switch family >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		//unix or unspecified, keep the addresses
+		return nil
+	}
+	if length < 2*ipLen+4 {
+		return errProxyHeader
+	}
+	src := net.IP(body[:ipLen])
+	dst := net.IP(body[ipLen : 2*ipLen])
+	sport := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dport := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	if family&0xf == 2 {
+		c.remote = &net.UDPAddr{IP: src, Port: sport}
+		c.local = &net.UDPAddr{IP: dst, Port: dport}
+	} else {
+		c.remote = &net.TCPAddr{IP: src, Port: sport}
+		c.local = &net.TCPAddr{IP: dst, Port: dport}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package overseer
+
+import (
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//proxyV2 builds a v2 header
+func proxyV2(verCmd, family byte, body ...byte) string {
+	b := append([]byte{}, proxyV2Sig...)
+	b = append(b, verCmd, family, byte(len(body)>>8), byte(len(body)))
+	return string(append(b, body...))
+}
+
+func TestProxyConn(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(append(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	tlv := append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0xff)
+	for _, test := range []struct {
+		name, input   string
+		remote, local string
+		err           bool
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", "[2001:db8::2]:443", false},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", "pipe", "pipe", false},
+		{"v1 unknown with addresses", "PROXY UNKNOWN ff:: ff:: 1 2\r\n", "pipe", "pipe", false},
+		{"v1 bad address", "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n", "", "", true},
+		{"v1 bad port", "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n", "", "", true},
+		{"v1 missing fields", "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n", "", "", true},
+		{"v1 bad protocol", "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n", "", "", true},
+		{"v1 no crlf", "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n", "", "", true},
+		{"v1 too long", "PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n", "", "", true},
+		{"v2 tcp4", proxyV2(0x21, 0x11, ipv4...), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v2 tcp6", proxyV2(0x21, 0x21, ipv6...), "[2001:db8::1]:56324", "[2001:db8::2]:443", false},
+		{"v2 udp4", proxyV2(0x21, 0x12, ipv4...), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v2 tlvs", proxyV2(0x21, 0x11, tlv...), "192.0.2.1:56324", "198.51.100.1:443", false},
+		{"v2 local", proxyV2(0x20, 0x00), "pipe", "pipe", false},
+		{"v2 unspecified", proxyV2(0x21, 0x00), "pipe", "pipe", false},
+		{"v2 short", proxyV2(0x21, 0x11, ipv4[:8]...), "", "", true},
+		{"v2 bad version", proxyV2(0x11, 0x11, ipv4...), "", "", true},
+		{"v2 bad command", proxyV2(0x22, 0x11, ipv4...), "", "", true},
+		{"no header", "GET / HTTP/1.1\r\n", "", "", true},
+		{"truncated", "PROXY TCP4 192.0.2.1", "", "", true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			go func() {
+				client.Write([]byte(test.input + "data"))
+				client.Close()
+			}()
+			conn := newProxyConn(server, &ProxyProtocol{Timeout: time.Second})
+			b, err := ioutil.ReadAll(conn)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, read %q", b)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "data" {
+				t.Fatalf("expected the data after the header, read %q", b)
+			}
+			if got := conn.RemoteAddr().String(); got != test.remote {
+				t.Fatalf("expected remote address %s, got %s", test.remote, got)
+			}
+			if got := conn.LocalAddr().String(); got != test.local {
+				t.Fatalf("expected local address %s, got %s", test.local, got)
+			}
+		})
+	}
+}
+
+func TestProxyConnTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newProxyConn(server, &ProxyProtocol{Timeout: 50 * time.Millisecond})
+	done := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected a timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("header timeout not applied")
+	}
+}
+
+func TestProxyTrusts(t *testing.T) {
+	trusted, err := parseTrusted("10.0.0.0/8,192.0.2.1,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyProtocol{Trusted: trusted}
+	for _, test := range []struct {
+		addr    net.Addr
+		trusted bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.2")}, false},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1")}, true},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::2")}, false},
+		{&net.UnixAddr{Name: "@", Net: "unix"}, false},
+	} {
+		if got := p.trusts(test.addr); got != test.trusted {
+			t.Errorf("%s: expected trusted %v, got %v", test.addr, test.trusted, got)
+		}
+	}
+	//fails closed
+	if (&ProxyProtocol{}).trusts(&net.TCPAddr{IP: net.ParseIP("10.1.2.3")}) {
+		t.Error("expected no trusted sources by default")
+	}
+	if _, err := parseTrusted("10.0.0.0/8,bogus"); err == nil {
+		t.Error("expected an invalid source")
+	}
+}
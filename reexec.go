@@ -0,0 +1,263 @@
+package overseer
+
+//with Config.ReexecMaster, the master process replaces itself
+//with the upgraded binary using execve, so it keeps its PID, and
+//its slave processes remain its children. the sockets, slaves and
+//their message pipes are described in the environment, and the
+//new master process adopts them instead of starting over.
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envMasterState = "OVERSEER_MASTER_STATE"
+
+//masterState is passed to the new master process
+type masterState struct {
+	SlaveID int            `json:"slave_id"`
+	Files   []int          `json:"files"`
+	FDNames []string       `json:"fd_names"`
+	Slaves  []adoptedSlave `json:"slaves"`
+	//see Config.HandoffConns
+	ConnsDir string `json:"conns_dir"`
+	//see Config.RollbackWindow
+	PrevHash       string    `json:"prev_hash"`
+	PrevLegacyHash string    `json:"prev_legacy_hash"`
+	ProbationUntil time.Time `json:"probation_until"`
+	BadHashes      []string  `json:"bad_hashes"`
+}
+
+type adoptedSlave struct {
+	ID        int       `json:"id"`
+	Worker    int       `json:"worker"`
+	PID       int       `json:"pid"`
+	Pipe      int       `json:"pipe"`
+	Hash      string    `json:"hash"`
+	Handoff   string    `json:"handoff"`
+	StartedAt time.Time `json:"started_at"`
+	//draining slaves are no longer a worker's slave
+	Draining bool `json:"draining"`
+}
+
+//takeMasterState returns the state passed by the previous
+//master process, or nil when this process wasn't re-executed
+func takeMasterState() *masterState {
+	env := os.Getenv(envMasterState)
+	if env == "" {
+		return nil
+	}
+	os.Unsetenv(envMasterState)
+	state := &masterState{}
+	if err := json.Unmarshal([]byte(env), state); err != nil {
+		return nil
+	}
+	return state
+}
+
+//reexec is called after an upgrade, and only returns on failure
+func (mp *master) reexec() {
+	//the new slaves may still be reading their inherited pipes,
+	//each handoff waits for the previous slave and then writes,
+	//with a HandoffTimeout each. any still running are cut short
+	if !mp.waitHandoffs(2 * mp.HandoffTimeout) {
+		mp.warnf("re-exec with handoffs in progress, which may be incomplete")
+	}
+	mp.exitMux.Lock()
+	defer mp.exitMux.Unlock()
+	if mp.exiting || mp.shuttingDown || mp.isRestarting() {
+		return
+	}
+	state := masterState{SlaveID: mp.slaveID, FDNames: mp.slaveFDNames, ConnsDir: mp.connsDir}
+	//the upgraded binary is still on probation
+	mp.binMux.Lock()
+	if mp.prevBinHash != nil {
+		state.PrevHash = hex.EncodeToString(mp.prevBinHash)
+		state.PrevLegacyHash = hex.EncodeToString(mp.prevBinLegacyHash)
+		state.ProbationUntil = mp.probationUntil
+	}
+	for h := range mp.badHashes {
+		state.BadHashes = append(state.BadHashes, h)
+	}
+	mp.binMux.Unlock()
+	//inherited descriptors, closed if the exec fails
+	fds := []int{}
+	inherit := func(f *os.File) (int, error) {
+		fd, err := inheritFD(f)
+		if err == nil {
+			fds = append(fds, fd)
+		}
+		return fd, err
+	}
+	defer func() {
+		for _, fd := range fds {
+			os.NewFile(uintptr(fd), "").Close()
+		}
+	}()
+	for _, f := range mp.slaveExtraFiles {
+		fd, err := inherit(f)
+		if err != nil {
+			mp.warnf("re-exec failed: %s", err)
+			return
+		}
+		state.Files = append(state.Files, fd)
+	}
+	for _, s := range mp.liveSlaves() {
+		a := adoptedSlave{
+			ID:        s.id,
+			Worker:    s.worker,
+			PID:       s.cmd.Process.Pid,
+			Pipe:      -1,
+			Hash:      hex.EncodeToString(s.binHash),
+			Handoff:   s.handoffPath,
+			StartedAt: s.startedAt,
+			Draining:  mp.workers[s.worker].current() != s,
+		}
+		//the pipe is closed once the slave has exited
+		if s.pipe != nil {
+			if fd, err := inherit(s.pipe); err == nil {
+				a.Pipe = fd
+			}
+		}
+		state.Slaves = append(state.Slaves, a)
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		mp.warnf("re-exec failed: %s", err)
+		return
+	}
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, envMasterState+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, envMasterState+"="+string(b))
+	//restore the systemd environment
+	if n := mp.notifier; n != nil {
+		env = append(env, envNotifySocket+"="+n.socket)
+		if n.watchdog > 0 {
+			env = append(env, envWatchdogUSec+"="+strconv.FormatInt(int64(n.watchdog/time.Microsecond), 10))
+			env = append(env, envWatchdogPID+"="+strconv.Itoa(os.Getpid()))
+		}
+	}
+	mp.debugf("re-executing master process with %d slaves", len(state.Slaves))
+	err = execve(mp.binPath, os.Args, env)
+	mp.warnf("re-exec failed: %s", err)
+}
+
+//adopt takes over the sockets and slaves of the previous
+//master process. it returns false when the sockets no longer
+//match the addresses, and the slaves need to be restarted.
+func (mp *master) adopt(state *masterState) bool {
+	mp.slaveID = state.SlaveID
+	mp.binMux.Lock()
+	if state.PrevHash != "" {
+		mp.prevBinHash, _ = hex.DecodeString(state.PrevHash)
+		mp.prevBinLegacyHash, _ = hex.DecodeString(state.PrevLegacyHash)
+		mp.probationUntil = state.ProbationUntil
+	}
+	for _, h := range state.BadHashes {
+		mp.badHashes[h] = true
+	}
+	mp.binMux.Unlock()
+	if mp.HandoffConns {
+		mp.connsDir = state.ConnsDir
+	} else if state.ConnsDir != "" {
+		os.RemoveAll(state.ConnsDir)
+	}
+	match := len(state.Files) == len(mp.Addresses)+len(mp.PacketAddresses)
+	if match {
+		for _, fd := range state.Files {
+			mp.slaveExtraFiles = append(mp.slaveExtraFiles, inheritedSocket(fd))
+		}
+		mp.slaveFDNames = make([]string, len(mp.Addresses))
+		copy(mp.slaveFDNames, state.FDNames)
+	} else {
+		mp.warnf("addresses changed, restarting programs")
+		for _, fd := range state.Files {
+			os.NewFile(uintptr(fd), "").Close()
+		}
+	}
+	for _, a := range state.Slaves {
+		proc, _ := os.FindProcess(a.PID)
+		hash, _ := hex.DecodeString(a.Hash)
+		s := newSlaveProcess(a.ID, a.Worker, &exec.Cmd{Path: mp.binPath, Process: proc}, hash)
+		s.startedAt = a.StartedAt
+		s.handoffPath = a.Handoff
+		s.adopted = true
+		s.markReady()
+		if a.Pipe >= 0 {
+			closeOnExec(uintptr(a.Pipe))
+			s.pipe = os.NewFile(uintptr(a.Pipe), "overseer-pipe")
+			go mp.readPipe(s, s.pipe)
+		}
+		mp.slaveLog(s).debugf("adopted slave#%d (pid %d)", s.id, a.PID)
+		mp.trackSlave(s)
+		go mp.wait(s)
+		if !a.Draining && a.Worker < len(mp.workers) && mp.workers[a.Worker].adopted == nil {
+			w := mp.workers[a.Worker]
+			w.adopted = s
+			w.setCurrent(s)
+			continue
+		}
+		//left over from the last restart, or no longer a worker
+		if !a.Draining {
+			s.draining()
+			proc.Signal(mp.drainSignal())
+		}
+		go func() {
+			select {
+			case <-s.exited:
+			case <-time.After(mp.TerminateTimeout):
+				mp.kill(s)
+			}
+		}()
+	}
+	return match
+}
+
+//trackSlave records the slave until it exits
+func (mp *master) trackSlave(s *slaveProcess) {
+	mp.slavesMux.Lock()
+	if mp.slaves == nil {
+		mp.slaves = map[*slaveProcess]bool{}
+	}
+	mp.slaves[s] = true
+	mp.slavesMux.Unlock()
+}
+
+func (mp *master) untrackSlave(s *slaveProcess) {
+	mp.slavesMux.Lock()
+	delete(mp.slaves, s)
+	mp.slavesMux.Unlock()
+}
+
+//liveSlaves returns all slaves which haven't exited,
+//including those still shutting down after a restart
+func (mp *master) liveSlaves() []*slaveProcess {
+	mp.slavesMux.Lock()
+	defer mp.slavesMux.Unlock()
+	slaves := make([]*slaveProcess, 0, len(mp.slaves))
+	for s := range mp.slaves {
+		slaves = append(slaves, s)
+	}
+	return slaves
+}
+
+//waitAdopted is cmd.Wait for adopted slaves
+func waitAdopted(s *slaveProcess) error {
+	state, err := s.cmd.Process.Wait()
+	if err != nil {
+		return err
+	}
+	if !state.Success() {
+		return &exec.ExitError{ProcessState: state}
+	}
+	return nil
+}
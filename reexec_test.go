@@ -0,0 +1,86 @@
+// +build linux darwin freebsd
+
+package overseer
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+//startProcess is startSlave, passing f to a process which exits
+func startProcess(t *testing.T, f *os.File) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReexecDrain(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := tl.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl.Close()
+	defer f.Close()
+	startProcess(t, f)
+	//the running slave
+	fl, err := net.FileListener(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(fl)
+	accepted := make(chan bool)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			accepted <- true
+		}
+	}()
+	dial := func() {
+		conn, err := net.Dial("tcp", fl.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		select {
+		case <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("not accepted")
+		}
+	}
+	dial()
+	//the new master process adopts the socket, and starts a slave
+	fd, err := inheritFD(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adopted := inheritedSocket(fd)
+	defer adopted.Close()
+	startProcess(t, adopted)
+	//the slave is accepting again, and then drained
+	dial()
+	time.Sleep(100 * time.Millisecond)
+	drained := make(chan bool)
+	go func() {
+		l.Drain(time.Second)
+		l.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain blocked by the re-exec")
+	}
+}
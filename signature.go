@@ -0,0 +1,72 @@
+package overseer
+
+//binaries are signed by signing the SHA-256 digest of the
+//published file (the gzipped binary for ".gz" files) with an
+//Ed25519 private key. signatures are published alongside it
+//(e.g. "app.gz.sig") and contain the base64 encoded signature.
+//keys are also passed around as base64 strings.
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+//ErrBadSignature is returned by Verify when the signature
+//does not match the binary with any of the public keys
+var ErrBadSignature = errors.New("signature does not match any public key")
+
+//ParsePublicKey decodes a base64 encoded Ed25519 public key,
+//as printed by the overseer-sign tool
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key (%s)", err)
+	}
+	if len(b) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key (%d bytes)", len(b))
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+//Sign returns the signature file contents for the file read
+//from r, to be published alongside it. r is the file as it is
+//published, such as app.gz rather than the extracted binary.
+func Sign(key ed25519.PrivateKey, r io.Reader) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, hash.Sum(nil))
+	return []byte(base64.StdEncoding.EncodeToString(sig) + "\n"), nil
+}
+
+//Verify checks the signature file contents against the
+//published file read from r, using any of the given public keys
+func Verify(keys []ed25519.PublicKey, r io.Reader, sig []byte) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return err
+	}
+	return verifyDigest(keys, hash.Sum(nil), sig)
+}
+
+func verifyDigest(keys []ed25519.PublicKey, digest, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid signature (%s)", err)
+	}
+	if len(raw) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature (%d bytes)", len(raw))
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, digest, raw) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
@@ -0,0 +1,83 @@
+package overseer
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	pub, priv := testKey(1)
+	other, _ := testKey(2)
+	sig, err := Sign(priv, strings.NewReader("app.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		keys []ed25519.PublicKey
+		file string
+		sig  []byte
+		err  error
+		//any error other than ErrBadSignature
+		invalid bool
+	}{
+		{"valid", []ed25519.PublicKey{pub}, "app.gz", sig, nil, false},
+		{"any key", []ed25519.PublicKey{other, pub}, "app.gz", sig, nil, false},
+		{"trailing whitespace", []ed25519.PublicKey{pub}, "app.gz", append(bytes.TrimSpace(sig), " \r\n"...), nil, false},
+		{"other key", []ed25519.PublicKey{other}, "app.gz", sig, ErrBadSignature, false},
+		{"no keys", nil, "app.gz", sig, ErrBadSignature, false},
+		{"other file", []ed25519.PublicKey{pub}, "app", sig, ErrBadSignature, false},
+		{"not base64", []ed25519.PublicKey{pub}, "app.gz", []byte("!!"), nil, true},
+		{"truncated", []ed25519.PublicKey{pub}, "app.gz", sig[:20], nil, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.keys, strings.NewReader(test.file), test.sig)
+			switch {
+			case test.invalid:
+				if err == nil || err == ErrBadSignature {
+					t.Fatalf("expected an invalid signature, got %v", err)
+				}
+			case err != test.err:
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _ := testKey(1)
+	encoded := base64.StdEncoding.EncodeToString(pub)
+	for _, test := range []struct {
+		name, key string
+		ok        bool
+	}{
+		{"valid", encoded, true},
+		{"trailing newline", encoded + "\n", true},
+		{"not base64", "!!", false},
+		{"short", encoded[:20], false},
+		{"empty", "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := ParsePublicKey(test.key)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(key, pub) {
+				t.Fatal("key mismatch")
+			}
+		})
+	}
+}
+
+func testKey(seed byte) (ed25519.PublicKey, ed25519.PrivateKey) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	return priv.Public().(ed25519.PublicKey), priv
+}
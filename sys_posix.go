@@ -7,6 +7,10 @@ package overseer
 //in some other way on other OSs... TODO!
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"syscall"
@@ -14,11 +18,13 @@ import (
 
 var (
 	supported = true
-	uid       = syscall.Getuid()
-	gid       = syscall.Getgid()
-	SIGUSR1   = syscall.SIGUSR1
-	SIGUSR2   = syscall.SIGUSR2
-	SIGTERM   = syscall.SIGTERM
+	//slaves are passed a message pipe with Cmd.ExtraFiles
+	pipesSupported = true
+	uid            = syscall.Getuid()
+	gid            = syscall.Getgid()
+	SIGUSR1        = syscall.SIGUSR1
+	SIGUSR2        = syscall.SIGUSR2
+	SIGTERM        = syscall.SIGTERM
 )
 
 func move(dst, src string) error {
@@ -46,3 +52,94 @@ func chmod(f *os.File, perms os.FileMode) error {
 func chown(f *os.File, uid, gid int) error {
 	return f.Chown(uid, gid)
 }
+
+func closeOnExec(fd uintptr) {
+	syscall.CloseOnExec(int(fd))
+}
+
+//inheritFD returns a duplicate of f's descriptor which is
+//inherited across exec. f.Fd() isn't used, as it would put the
+//socket, shared with the running slaves, into blocking mode.
+func inheritFD(f *os.File) (int, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd, dupErr := -1, error(nil)
+	if err := raw.Control(func(sysfd uintptr) {
+		fd, dupErr = syscall.Dup(int(sysfd))
+	}); err != nil {
+		return -1, err
+	}
+	return fd, dupErr
+}
+
+//inheritedSocket opens a socket passed with inheritFD, which is
+//later passed to slaves. starting a slave calls its Fd(), putting
+//it into blocking mode once, so that's done here, and undone
+//while the slaves are still accepting on it.
+func inheritedSocket(fd int) *os.File {
+	syscall.CloseOnExec(fd)
+	f := os.NewFile(uintptr(fd), "")
+	f.Fd()
+	syscall.SetNonblock(fd, true)
+	return f
+}
+
+func execve(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
+
+//sendConn passes the descriptor with the first byte,
+//followed by the metadata, see State.HandoffConn
+func sendConn(u *net.UnixConn, fd uintptr, metadata []byte) error {
+	b := append([]byte{0}, metadata...)
+	n, _, err := u.WriteMsgUnix(b, syscall.UnixRights(int(fd)), nil)
+	if err != nil {
+		return err
+	}
+	if n < len(b) {
+		_, err = u.Write(b[n:])
+	}
+	return err
+}
+
+//recvConn receives a connection sent by sendConn
+func recvConn(u *net.UnixConn, limit int64) (net.Conn, []byte, error) {
+	b := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := u.ReadMsgUnix(b, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, nil, err
+	} else if len(msgs) != 1 {
+		return nil, nil, errors.New("no file descriptor")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make([]*os.File, len(fds))
+	for i, fd := range fds {
+		syscall.CloseOnExec(fd)
+		files[i] = os.NewFile(uintptr(fd), "overseer-conn")
+		defer files[i].Close()
+	}
+	if len(files) != 1 {
+		return nil, nil, errors.New("no file descriptor")
+	}
+	metadata, err := ioutil.ReadAll(io.LimitReader(u, limit+1))
+	if err != nil {
+		return nil, nil, err
+	} else if int64(len(metadata)) > limit {
+		return nil, nil, ErrHandoffTooLarge
+	}
+	conn, err := net.FileConn(files[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, metadata, nil
+}
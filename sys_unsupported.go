@@ -4,16 +4,19 @@ package overseer
 
 import (
 	"errors"
+	"net"
 	"os"
 )
 
 var (
 	supported = false
-	uid       = 0
-	gid       = 0
-	SIGUSR1   = os.Interrupt
-	SIGUSR2   = os.Interrupt
-	SIGTERM   = os.Kill
+	//slaves are passed a message pipe with Cmd.ExtraFiles
+	pipesSupported = false
+	uid            = 0
+	gid            = 0
+	SIGUSR1        = os.Interrupt
+	SIGUSR2        = os.Interrupt
+	SIGTERM        = os.Kill
 )
 
 func move(dst, src string) error {
@@ -27,3 +30,27 @@ func chmod(f *os.File, perms os.FileMode) error {
 func chown(f *os.File, uid, gid int) error {
 	return errors.New("Not supported")
 }
+
+func closeOnExec(fd uintptr) {
+	//not supported
+}
+
+func inheritFD(f *os.File) (int, error) {
+	return 0, errors.New("Not supported")
+}
+
+func inheritedSocket(fd int) *os.File {
+	return os.NewFile(uintptr(fd), "")
+}
+
+func execve(path string, args, env []string) error {
+	return errors.New("Not supported")
+}
+
+func sendConn(u *net.UnixConn, fd uintptr, metadata []byte) error {
+	return errors.New("Not supported")
+}
+
+func recvConn(u *net.UnixConn, limit int64) (net.Conn, []byte, error) {
+	return nil, nil, errors.New("Not supported")
+}
@@ -5,6 +5,7 @@ package overseer
 import (
 	"bytes"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -14,11 +15,13 @@ import (
 
 var (
 	supported = true
-	uid       = syscall.Getuid()
-	gid       = syscall.Getgid()
-	SIGUSR1   = syscall.SIGTERM
-	SIGUSR2   = syscall.SIGTERM
-	SIGTERM   = syscall.SIGTERM
+	//slaves are passed a message pipe with Cmd.ExtraFiles
+	pipesSupported = false
+	uid            = syscall.Getuid()
+	gid            = syscall.Getgid()
+	SIGUSR1        = syscall.SIGTERM
+	SIGUSR2        = syscall.SIGTERM
+	SIGTERM        = syscall.SIGTERM
 )
 
 func move(dst, src string) error {
@@ -53,6 +56,10 @@ func chown(f *os.File, uid, gid int) error {
 	return nil
 }
 
+func closeOnExec(fd uintptr) {
+	//file descriptors are not inherited on windows
+}
+
 // https://blogs.msdn.microsoft.com/twistylittlepassagesallalike/2011/04/23/everyone-quotes-command-line-arguments-the-wrong-way/
 var replShellMeta = strings.NewReplacer(
 	`(`, `^(`,
@@ -66,3 +73,23 @@ var replShellMeta = strings.NewReplacer(
 	`&`, `^&`,
 	`|`, `^|`,
 )
+
+func inheritFD(f *os.File) (int, error) {
+	return 0, fmt.Errorf("Not supported")
+}
+
+func inheritedSocket(fd int) *os.File {
+	return os.NewFile(uintptr(fd), "")
+}
+
+func execve(path string, args, env []string) error {
+	return fmt.Errorf("Not supported")
+}
+
+func sendConn(u *net.UnixConn, fd uintptr, metadata []byte) error {
+	return fmt.Errorf("Not supported")
+}
+
+func recvConn(u *net.UnixConn, limit int64) (net.Conn, []byte, error) {
+	return nil, nil, fmt.Errorf("Not supported")
+}
@@ -0,0 +1,174 @@
+package overseer
+
+//systemd socket activation, see sd_listen_fds(3). sockets
+//passed in by systemd are adopted by the master process
+//instead of binding new ones, and are then passed on to
+//the slave processes like any other socket.
+//
+//systemd notifications, see sd_notify(3). the master process
+//is the main process of the unit, so it alone reports the
+//service status on behalf of the slave processes.
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+	envNotifySocket  = "NOTIFY_SOCKET"
+	envWatchdogUSec  = "WATCHDOG_USEC"
+	envWatchdogPID   = "WATCHDOG_PID"
+	//first file descriptor passed by systemd
+	listenFDsStart = 3
+)
+
+type activatedFile struct {
+	name string
+	file *os.File
+	used bool
+}
+
+//activatedFiles returns the sockets passed to this process
+//by systemd, or nil when not socket activated. the systemd
+//environment is cleared so it isn't passed on to slaves.
+func activatedFiles() []*activatedFile {
+	pid, err := strconv.Atoi(os.Getenv(envListenPID))
+	if err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+	files := make([]*activatedFile, n)
+	for i := 0; i < n; i++ {
+		fd := uintptr(listenFDsStart + i)
+		closeOnExec(fd)
+		name := "unknown" //systemd default
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = &activatedFile{name: name, file: os.NewFile(fd, name)}
+	}
+	return files
+}
+
+//adopt finds the activated socket for the given address, either
+//by name (systemd://name) or by matching its bound address
+func (a *address) adopt(files []*activatedFile, packet bool) *activatedFile {
+	for _, f := range files {
+		if f.used {
+			continue
+		}
+		if a.network == "systemd" {
+			if f.name == a.addr {
+				f.used = true
+				return f
+			}
+			continue
+		}
+		var local net.Addr
+		if packet {
+			c, err := net.FilePacketConn(f.file)
+			if err != nil {
+				continue
+			}
+			local = c.LocalAddr()
+			c.Close()
+		} else {
+			l, err := net.FileListener(f.file)
+			if err != nil {
+				continue
+			}
+			local = l.Addr()
+			l.Close()
+		}
+		if a.matches(local) {
+			f.used = true
+			return f
+		}
+	}
+	return nil
+}
+
+//matches compares this address with an already bound address
+func (a *address) matches(bound net.Addr) bool {
+	var ip, boundIP net.IP
+	var port, boundPort int
+	switch b := bound.(type) {
+	case *net.UnixAddr:
+		return a.isUnix() && a.addr == b.Name
+	case *net.TCPAddr:
+		t, err := net.ResolveTCPAddr(a.network, a.addr)
+		if err != nil || a.isUnix() {
+			return false
+		}
+		ip, port, boundIP, boundPort = t.IP, t.Port, b.IP, b.Port
+	case *net.UDPAddr:
+		u, err := net.ResolveUDPAddr(a.network, a.addr)
+		if err != nil || a.isUnix() {
+			return false
+		}
+		ip, port, boundIP, boundPort = u.IP, u.Port, b.IP, b.Port
+	default:
+		return false
+	}
+	if port != boundPort {
+		return false
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return boundIP == nil || boundIP.IsUnspecified()
+	}
+	return ip.Equal(boundIP)
+}
+
+type notifier struct {
+	socket   string
+	watchdog time.Duration
+}
+
+//newNotifier returns nil when not run under a Type=notify unit.
+//the systemd environment is cleared so it isn't passed on to slaves.
+func newNotifier() *notifier {
+	socket := os.Getenv(envNotifySocket)
+	if socket == "" {
+		return nil
+	}
+	//abstract "@" sockets are handled by package net
+	n := &notifier{socket: socket}
+	usec, err := strconv.Atoi(os.Getenv(envWatchdogUSec))
+	if err == nil && usec > 0 {
+		pid, err := strconv.Atoi(os.Getenv(envWatchdogPID))
+		if err != nil || pid == os.Getpid() {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	os.Unsetenv(envNotifySocket)
+	os.Unsetenv(envWatchdogUSec)
+	os.Unsetenv(envWatchdogPID)
+	return n
+}
+
+//notify sends newline separated assignments, such as "READY=1"
+func (n *notifier) notify(state string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: n.socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,112 @@
+// +build linux darwin freebsd
+
+package overseer
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+//listenNotify is a stand-in for systemd's notification socket
+func listenNotify(t *testing.T) (string, *net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "overseer-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return path, conn, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func setenv(env map[string]string) func() {
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, test := range []struct {
+		name     string
+		env      map[string]string
+		disabled bool
+		watchdog time.Duration
+	}{
+		{"not notify", map[string]string{envWatchdogUSec: "1000000"}, true, 0},
+		{"notify", map[string]string{envNotifySocket: "/run/notify"}, false, 0},
+		{"watchdog", map[string]string{envNotifySocket: "/run/notify", envWatchdogUSec: "1000000"}, false, time.Second},
+		{"watchdog pid", map[string]string{envNotifySocket: "/run/notify", envWatchdogUSec: "500", envWatchdogPID: pid}, false, 500 * time.Microsecond},
+		{"watchdog other pid", map[string]string{envNotifySocket: "/run/notify", envWatchdogUSec: "1000000", envWatchdogPID: "1"}, false, 0},
+		{"watchdog invalid", map[string]string{envNotifySocket: "/run/notify", envWatchdogUSec: "-1"}, false, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			defer setenv(test.env)()
+			n := newNotifier()
+			if test.disabled {
+				if n != nil {
+					t.Fatalf("expected no notifier, got %+v", n)
+				}
+				return
+			}
+			if n == nil {
+				t.Fatal("expected a notifier")
+			}
+			if n.socket != "/run/notify" || n.watchdog != test.watchdog {
+				t.Fatalf("expected watchdog %s, got %+v", test.watchdog, n)
+			}
+			for _, k := range []string{envNotifySocket, envWatchdogUSec, envWatchdogPID} {
+				if v := os.Getenv(k); v != "" {
+					t.Fatalf("expected %s to be cleared, got %q", k, v)
+				}
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	path, conn, cleanup := listenNotify(t)
+	defer cleanup()
+	defer setenv(map[string]string{envNotifySocket: path})()
+	mp := &master{Config: &Config{}, notifier: newNotifier()}
+	recv := func() string {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		b := make([]byte, 1024)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	}
+	mp.notifyReady()
+	if got, want := recv(), "READY=1\nMAINPID="+strconv.Itoa(os.Getpid()); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+	for _, state := range []string{"RELOADING=1", "WATCHDOG=1", "STOPPING=1"} {
+		mp.notify(state)
+		if got := recv(); got != state {
+			t.Fatalf("expected %q, got %q", state, got)
+		}
+	}
+	//not run under a Type=notify unit
+	var n *notifier
+	if err := n.notify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+}
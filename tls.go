@@ -0,0 +1,207 @@
+package overseer
+
+//listeners of addresses in Config.TLS accept TLS connections.
+//the certificates are loaded by each program, and reloaded
+//when their files change (checked at most once a second,
+//during handshakes) or on Config.TLSReloadSignal.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+//TLSConfig configures TLS termination for an address
+type TLSConfig struct {
+	//CertFile and KeyFile are the PEM encoded certificate
+	//(followed by any intermediates) and private key
+	CertFile string
+	KeyFile  string
+	//ClientCAFile enables client certificate verification,
+	//against the PEM encoded certificates in this file
+	ClientCAFile string
+	//MinVersion such as tls.VersionTLS13. Defaults to tls.VersionTLS12.
+	MinVersion uint16
+}
+
+func (c TLSConfig) files() []string {
+	files := []string{c.CertFile, c.KeyFile}
+	if c.ClientCAFile != "" {
+		files = append(files, c.ClientCAFile)
+	}
+	return files
+}
+
+//tlsReloader holds the current tls.Config of a listener
+type tlsReloader struct {
+	TLSConfig
+	log       logger
+	mut       sync.Mutex
+	config    *tls.Config
+	modTimes  []time.Time
+	checkedAt time.Time
+}
+
+func newTLSReloader(c TLSConfig, l logger) (*tlsReloader, error) {
+	r := &tlsReloader{TLSConfig: c, log: l}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//load replaces the current config, which is kept on failure
+func (r *tlsReloader) load() error {
+	modTimes := r.stat()
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s (%s)", r.CertFile, err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   r.MinVersion,
+	}
+	if config.MinVersion == 0 {
+		config.MinVersion = tls.VersionTLS12
+	}
+	if r.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client CA %s (%s)", r.ClientCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("failed to load client CA %s (no certificates)", r.ClientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	r.mut.Lock()
+	r.config = config
+	r.modTimes = modTimes
+	r.mut.Unlock()
+	return nil
+}
+
+//stat returns the modification times of the files
+func (r *tlsReloader) stat() []time.Time {
+	files := r.files()
+	modTimes := make([]time.Time, len(files))
+	for i, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+//changed returns whether any file was modified since it was loaded,
+//only checking once a second
+func (r *tlsReloader) changed() bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if time.Since(r.checkedAt) < time.Second {
+		return false
+	}
+	r.checkedAt = time.Now()
+	for i, t := range r.stat() {
+		if !t.Equal(r.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *tlsReloader) reload() {
+	if err := r.load(); err != nil {
+		r.log.warnf("tls reload failed: %s", err)
+		return
+	}
+	r.log.debugf("reloaded certificate %s", r.CertFile)
+}
+
+//getConfigForClient is the listener's tls.Config.GetConfigForClient
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	if r.changed() {
+		r.reload()
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.config, nil
+}
+
+//listen wraps the listener, the connections are
+//still tracked by the inner Listener
+func (r *tlsReloader) listen(l net.Listener) net.Listener {
+	return tls.NewListener(l, &tls.Config{GetConfigForClient: r.getConfigForClient})
+}
+
+//checkTLS loads the certificates in the master process,
+//so missing or invalid files are reported before starting
+func (mp *master) checkTLS() error {
+	for addr, c := range mp.TLS {
+		if _, err := newTLSReloader(c, mp.logger()); err != nil {
+			return fmt.Errorf("Invalid TLS config for %s: %s", addr, err)
+		}
+	}
+	return nil
+}
+
+//initTLS wraps State.Listeners of addresses in Config.TLS
+func (sp *slave) initTLS() error {
+	reloaders := []*tlsReloader{}
+	for i, addr := range sp.Addresses {
+		c, ok := sp.TLS[addr]
+		if !ok || i >= len(sp.listeners) {
+			continue
+		}
+		r, err := newTLSReloader(c, sp.logger().with("address", addr))
+		if err != nil {
+			return err
+		}
+		reloaders = append(reloaders, r)
+		sp.state.Listeners[i] = r.listen(sp.listeners[i])
+	}
+	if sp.TLSReloadSignal == nil || len(reloaders) == 0 {
+		return nil
+	}
+	//passed through by the master process
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sp.TLSReloadSignal)
+	go func() {
+		for range signals {
+			for _, r := range reloaders {
+				r.reload()
+			}
+		}
+	}()
+	return nil
+}
+
+func validateTLS(c *Config) error {
+	if len(c.TLS) > 0 && len(c.Command) > 0 {
+		return errors.New("overseer.Config.TLS and Command cant both be set")
+	}
+	for addr, t := range c.TLS {
+		found := false
+		for _, a := range c.Addresses {
+			found = found || a == addr
+		}
+		if !found {
+			return fmt.Errorf("overseer.Config.TLS address %s not in Addresses", addr)
+		} else if t.CertFile == "" || t.KeyFile == "" {
+			return fmt.Errorf("overseer.Config.TLS for %s requires CertFile and KeyFile", addr)
+		}
+	}
+	if c.TLSReloadSignal != nil && c.TLSReloadSignal == c.RestartSignal {
+		return errors.New("overseer.Config.TLSReloadSignal cant be the RestartSignal")
+	}
+	return nil
+}